@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// RestartPolicy configures how ClientConfig.AutoRestart relaunches a plugin
+// that crashed.
+type RestartPolicy struct {
+	// MaxRetries is the number of attempts to relaunch the plugin after each
+	// crash before giving up. Zero means a single attempt.
+	MaxRetries int
+
+	// Backoff is the delay before the first attempt, doubled for every
+	// following one. Defaults to 100ms.
+	Backoff time.Duration
+
+	// MaxBackoff caps the delay between attempts. Defaults to 30s.
+	MaxBackoff time.Duration
+
+	// CrashLoopLimit, if positive, is the number of crashes within
+	// CrashLoopWindow after which the plugin is considered crash looping:
+	// it is not restarted anymore, ClientConfig.OnCrashLoop is called and
+	// Start returns ErrCrashLooping.
+	CrashLoopLimit int
+
+	// CrashLoopWindow is the window crashes are counted in for
+	// CrashLoopLimit. Defaults to a minute.
+	CrashLoopWindow time.Duration
+}
+
+// delay returns the delay before the given attempt, counting from zero.
+func (p *RestartPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	if d <= 0 {
+		d = 100 * time.Millisecond
+	}
+	max := p.MaxBackoff
+	if max <= 0 {
+		max = 30 * time.Second
+	}
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// restartableClient is the ClientProtocol returned by Client when
+// AutoRestart is set. It always uses the connection to the current plugin
+// process, so it keeps working across restarts.
+type restartableClient struct {
+	c *Client
+}
+
+// ClientProtocol impl.
+func (r *restartableClient) Close() error {
+	client, err := r.c.protocolClient()
+	if err != nil {
+		return err
+	}
+	return client.Close()
+}
+
+// ClientProtocol impl.
+func (r *restartableClient) Dispense(name string) (interface{}, error) {
+	client, err := r.c.protocolClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.Dispense(name)
+}
+
+// ClientProtocol impl.
+func (r *restartableClient) Ping() error {
+	client, err := r.c.protocolClient()
+	if err != nil {
+		return err
+	}
+	return client.Ping()
+}
+
+// autoRestart relaunches the plugin after it exited unexpectedly, once the
+// goroutines of the exited process finished, as configured by
+// ClientConfig.AutoRestart.
+func (c *Client) autoRestart(exited context.Context) {
+	<-exited.Done()
+
+	c.m.Lock()
+	c.restarting = true
+	c.m.Unlock()
+	defer func() {
+		c.m.Lock()
+		c.restarting = false
+		c.m.Unlock()
+	}()
+
+	policy := c.config.AutoRestart
+	began := time.Now()
+	for attempt := 0; attempt <= policy.MaxRetries; attempt++ {
+		time.Sleep(policy.delay(attempt))
+
+		if !c.resetForRestart() {
+			return
+		}
+
+		_, err := c.Start()
+		if err == nil {
+			c.m.Lock()
+			killing := c.killing
+			c.restartCount++
+			var name string
+			if r, ok := c.runner.(interface{ Name() string }); ok {
+				name = r.Name()
+			}
+			c.m.Unlock()
+
+			// Kill may have run while the plugin was being launched.
+			if killing {
+				c.Kill()
+				return
+			}
+			c.logger.Info("restarted plugin after crash", "attempt", attempt+1)
+			c.event(slog.LevelInfo, "plugin restarted", "attempt", attempt+1)
+			c.lifecycle(LifecycleRestarted, name, time.Since(began), nil)
+			return
+		}
+		c.logger.Error("failed to restart plugin", "attempt", attempt+1, "error", err)
+	}
+	c.logger.Error("giving up restarting plugin", "maxRetries", policy.MaxRetries)
+}
+
+// resetForRestart clears the state of the exited plugin so Start launches
+// it again. It reports false if the client is being killed or crash looping.
+func (c *Client) resetForRestart() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.killing || c.crashLooping {
+		return false
+	}
+
+	if c.client != nil {
+		if d, ok := c.client.(interface{ disconnect() error }); ok {
+			d.disconnect()
+		}
+		c.client = nil
+	}
+	if c.unixSocketCfg.socketDir != "" {
+		os.RemoveAll(c.unixSocketCfg.socketDir)
+		c.unixSocketCfg.socketDir = ""
+	}
+
+	c.address = nil
+	c.pluginAddrs = nil
+	c.runner = nil
+	c.exited = false
+	if c.config.Cmd != nil {
+		c.config.Cmd = cloneCmd(c.cmdTemplate)
+	}
+
+	// Drop a diagnostic left over by the crashed process.
+	c.startupDiag.Store(nil)
+	select {
+	case <-c.startupFailed:
+	default:
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// backpressureKey is the trailer the plugin sets on every response while it
+// is overloaded.
+const backpressureKey = "plugin-backpressure"
+
+// backpressurePollInterval is how often the client asks an overloaded plugin
+// whether it recovered, since the host may have stopped sending requests
+// that would tell it.
+var backpressurePollInterval = 500 * time.Millisecond
+
+// overloaded is set by SetBackpressure. There is one plugin server per
+// process, so it is process wide.
+var overloaded atomic.Bool
+
+// SetBackpressure tells the host to slow down or stop sending new requests
+// while on is true, e.g. because the plugin is running out of memory. It is
+// reported to the host with every response, and to hosts that set
+// ClientConfig.OnBackpressure. Hosts that don't are not affected.
+func SetBackpressure(on bool) {
+	overloaded.Store(on)
+}
+
+// backpressureTrailer returns the trailer reporting the backpressure state,
+// or nil if the plugin is not overloaded.
+func backpressureTrailer() metadata.MD {
+	if !overloaded.Load() {
+		return nil
+	}
+	return metadata.Pairs(backpressureKey, "1")
+}
+
+func backpressureUnaryServerInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if md := backpressureTrailer(); md != nil {
+		grpc.SetTrailer(ctx, md)
+	}
+	return resp, err
+}
+
+func backpressureStreamServerInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	err := handler(srv, ss)
+	if md := backpressureTrailer(); md != nil {
+		ss.SetTrailer(md)
+	}
+	return err
+}
+
+// backpressureTracker follows the backpressure state reported by the
+// plugin in the trailers of its responses and calls onBackpressure when it
+// becomes overloaded.
+type backpressureTracker struct {
+	doneCtx        context.Context
+	onBackpressure func(resume <-chan struct{})
+
+	m      sync.Mutex
+	resume chan struct{}
+}
+
+func newBackpressureTracker(doneCtx context.Context, onBackpressure func(resume <-chan struct{})) *backpressureTracker {
+	return &backpressureTracker{doneCtx: doneCtx, onBackpressure: onBackpressure}
+}
+
+// observe updates the state from the trailer of a response received on cc.
+func (t *backpressureTracker) observe(cc *grpc.ClientConn, md metadata.MD) {
+	on := len(md.Get(backpressureKey)) > 0
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	switch {
+	case on && t.resume == nil:
+		t.resume = make(chan struct{})
+		go t.onBackpressure(t.resume)
+		go t.poll(cc, t.resume)
+	case !on && t.resume != nil:
+		close(t.resume)
+		t.resume = nil
+	}
+}
+
+// poll checks the health of the plugin, which reports the backpressure
+// state like any other call, until the plugin stopped pushing back.
+func (t *backpressureTracker) poll(cc *grpc.ClientConn, resume chan struct{}) {
+	ticker := time.NewTicker(backpressurePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-resume:
+			return
+		case <-t.doneCtx.Done():
+			return
+		case <-ticker.C:
+			grpc_health_v1.NewHealthClient(cc).Check(t.doneCtx, &grpc_health_v1.HealthCheckRequest{
+				Service: GRPCServiceName,
+			})
+		}
+	}
+}
+
+func (t *backpressureTracker) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	var md metadata.MD
+	err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Trailer(&md))...)
+	t.observe(cc, md)
+	return err
+}
+
+func (t *backpressureTracker) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// The trailer is observed once the stream finished. A cancelled stream
+	// has none, and may not have finished reading it yet.
+	return newDoneStream(ctx, stream, desc, func() {
+		if ctx.Err() == nil {
+			t.observe(cc, stream.Trailer())
+		}
+	}), nil
+}
@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestClientOnBackpressure(t *testing.T) {
+	defer func(interval time.Duration) { backpressurePollInterval = interval }(backpressurePollInterval)
+	backpressurePollInterval = 50 * time.Millisecond
+
+	setBackpressure := func(on bool) func(t *testing.T, c *Client) {
+		return func(t *testing.T, c *Client) {
+			err := testCall(context.Background(), testDispense(t, c), "SetBackpressure", wrapperspb.Bool(on), new(emptypb.Empty))
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+		}
+	}
+	ping := func(t *testing.T, c *Client) {
+		client, err := c.Client()
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		client.Ping()
+	}
+
+	cases := []struct {
+		name string
+		mode string
+		// overload makes the plugin report backpressure, and resume
+		// makes it stop, if set.
+		overload func(t *testing.T, c *Client)
+		resume   func(t *testing.T, c *Client)
+	}{
+		{"resumed by a call", "test-grpc", setBackpressure(true), setBackpressure(false)},
+		{"resumed while polling", "overloaded", ping, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resumes := make(chan (<-chan struct{}), 1)
+			config := testClientConfig(tc.mode)
+			config.OnBackpressure = func(resume <-chan struct{}) { resumes <- resume }
+			c := testStartClient(t, config)
+
+			tc.overload(t, c)
+			var resume <-chan struct{}
+			select {
+			case resume = <-resumes:
+			case <-time.After(5 * time.Second):
+				t.Fatal("OnBackpressure was not called")
+			}
+
+			if tc.resume != nil {
+				tc.resume(t, c)
+			}
+			select {
+			case <-resume:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the host was not told the plugin recovered")
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kform-dev/plugin/internal/plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// CancelReason is the reason the host cancelled a call to the plugin. The host
+// attaches a reason by cancelling the context of the call with it as the
+// cause:
+//
+//	ctx, cancel := context.WithCancelCause(ctx)
+//	...
+//	cancel(plugin.CancelReasonUserAbort)
+//
+// The client then tells the plugin why the call is being cancelled before the
+// call itself is cancelled, and the plugin can read it with
+// CancelReasonFromContext.
+type CancelReason string
+
+// Common cancellation reasons. Any other string can be used as well.
+const (
+	CancelReasonTimeout   CancelReason = "timeout"
+	CancelReasonUserAbort CancelReason = "user abort"
+	CancelReasonShutdown  CancelReason = "shutdown"
+)
+
+func (r CancelReason) Error() string {
+	return "call cancelled: " + string(r)
+}
+
+// CancelReasonFromContext returns the reason the host gave for cancelling the
+// call whose server-side context is ctx. It returns false if the call was
+// not cancelled with a reason.
+func CancelReasonFromContext(ctx context.Context) (CancelReason, bool) {
+	var reason CancelReason
+	if errors.As(context.Cause(ctx), &reason) {
+		return reason, true
+	}
+
+	// The call may have been cancelled by gRPC before the reason arrived.
+	if call, ok := ctx.Value(cancelCallKey{}).(*cancelCall); ok {
+		if r := call.reason.Load(); r != nil {
+			return *r, true
+		}
+	}
+	return "", false
+}
+
+// cancelCallIDKey is the metadata key carrying the id used to refer to a
+// call when cancelling it.
+const cancelCallIDKey = "plugin-call-id"
+
+// cancelReasonTimeout bounds how long the client waits for the plugin to
+// acknowledge a cancellation reason before cancelling the call anyway.
+const cancelReasonTimeout = time.Second
+
+// controllerMethodPrefix is the prefix of the controller's methods, which are
+// never tagged with a call id.
+const controllerMethodPrefix = "/plugin.GRPCController/"
+
+var nextCancelCallID uint64
+
+// withCancelReason prepares a call on cc for cancellation with a reason. It
+// returns the context to make the call with, which is cancelled after the
+// plugin was sent the reason the parent context was cancelled with, and a
+// function to release the resources once the call is done.
+func withCancelReason(ctx context.Context, cc *grpc.ClientConn) (context.Context, func()) {
+	id := strconv.FormatUint(atomic.AddUint64(&nextCancelCallID, 1), 10)
+
+	// The call keeps the values and deadline of ctx, but is only cancelled
+	// once the plugin knows why.
+	var callCtx context.Context
+	var cancel context.CancelFunc
+	if deadline, ok := ctx.Deadline(); ok {
+		callCtx, cancel = context.WithDeadline(context.WithoutCancel(ctx), deadline)
+	} else {
+		callCtx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+	}
+	callCtx = metadata.AppendToOutgoingContext(callCtx, cancelCallIDKey, id)
+
+	stop := context.AfterFunc(ctx, func() {
+		var reason CancelReason
+		if errors.As(context.Cause(ctx), &reason) {
+			sendCtx, sendCancel := context.WithTimeout(context.WithoutCancel(ctx), cancelReasonTimeout)
+			// Plugins that don't support cancellation reasons return
+			// Unimplemented, in which case the call is simply cancelled.
+			plugin.NewGRPCControllerClient(cc).Cancel(sendCtx, &plugin.CancelRequest{
+				CallId: id,
+				Reason: string(reason),
+			})
+			sendCancel()
+		}
+		cancel()
+	})
+
+	return callCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// cancelReasonUnaryInterceptor tags each unary call with a call id so it can
+// be cancelled with a reason.
+func cancelReasonUnaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if strings.HasPrefix(method, controllerMethodPrefix) {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	callCtx, done := withCancelReason(ctx, cc)
+	defer done()
+	return invoker(callCtx, method, req, reply, cc, opts...)
+}
+
+// cancelReasonStreamInterceptor tags each stream with a call id so it can be
+// cancelled with a reason.
+func cancelReasonStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if strings.HasPrefix(method, controllerMethodPrefix) {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+
+	callCtx, done := withCancelReason(ctx, cc)
+	stream, err := streamer(callCtx, desc, cc, method, opts...)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	// The stream finishes on callCtx rather than ctx, so the plugin is still
+	// sent the reason when ctx is cancelled.
+	return newDoneStream(callCtx, stream, desc, done), nil
+}
+
+// cancelCallKey is the context key of the *cancelCall of a server-side call.
+type cancelCallKey struct{}
+
+// cancelCall is the server-side state of a call that can be cancelled with a
+// reason.
+type cancelCall struct {
+	cancel context.CancelCauseFunc
+	reason atomic.Pointer[CancelReason]
+}
+
+// cancelRegistry tracks the in-flight calls on the plugin side, so the
+// controller can cancel them with the reason sent by the host.
+type cancelRegistry struct {
+	m     sync.Mutex
+	calls map[string]*cancelCall
+}
+
+func newCancelRegistry() *cancelRegistry {
+	return &cancelRegistry{calls: make(map[string]*cancelCall)}
+}
+
+// track registers the call in ctx, if it has a call id, and returns the
+// context to serve it with and a function to deregister it.
+func (r *cancelRegistry) track(ctx context.Context) (context.Context, func()) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ids := md.Get(cancelCallIDKey)
+	if len(ids) == 0 {
+		return ctx, func() {}
+	}
+	id := ids[0]
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	call := &cancelCall{cancel: cancel}
+	ctx = context.WithValue(ctx, cancelCallKey{}, call)
+
+	r.m.Lock()
+	r.calls[id] = call
+	r.m.Unlock()
+
+	return ctx, func() {
+		r.m.Lock()
+		delete(r.calls, id)
+		r.m.Unlock()
+		cancel(nil)
+	}
+}
+
+// cancel cancels the call with the given id with reason.
+func (r *cancelRegistry) cancel(id string, reason CancelReason) {
+	r.m.Lock()
+	call, ok := r.calls[id]
+	r.m.Unlock()
+	if !ok {
+		return
+	}
+
+	call.reason.Store(&reason)
+	call.cancel(reason)
+}
+
+func (r *cancelRegistry) unaryInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, done := r.track(ctx)
+	defer done()
+	return handler(ctx, req)
+}
+
+func (r *cancelRegistry) streamInterceptor(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, done := r.track(ss.Context())
+	defer done()
+	return handler(srv, &cancelReasonServerStream{ServerStream: ss, ctx: ctx})
+}
+
+// cancelReasonServerStream overrides the context of a server stream.
+type cancelReasonServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *cancelReasonServerStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,58 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestClientCancelReason(t *testing.T) {
+	cases := []struct {
+		name   string
+		cancel func(context.CancelCauseFunc)
+		want   string
+	}{
+		{"user abort", func(cancel context.CancelCauseFunc) { cancel(CancelReasonUserAbort) }, string(CancelReasonUserAbort)},
+		{"custom reason", func(cancel context.CancelCauseFunc) { cancel(CancelReason("custom")) }, "custom"},
+		{"wrapped reason", func(cancel context.CancelCauseFunc) {
+			cancel(errors.Join(errors.New("stopping"), CancelReasonShutdown))
+		}, string(CancelReasonShutdown)},
+		{"without reason", func(cancel context.CancelCauseFunc) { cancel(nil) }, ""},
+	}
+
+	c := testStartClient(t, testClientConfig("test-grpc"))
+	conn := testDispense(t, c)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancelCause(context.Background())
+			done := make(chan error)
+			go func() { done <- testCall(ctx, conn, "Block", new(emptypb.Empty), new(emptypb.Empty)) }()
+			time.Sleep(100 * time.Millisecond)
+			tc.cancel(cancel)
+			if err := <-done; err == nil {
+				t.Fatal("cancelled call succeeded")
+			}
+
+			// The plugin records the reason once it saw the call cancelled.
+			var reason *wrapperspb.StringValue
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				reason = new(wrapperspb.StringValue)
+				if err := testCall(context.Background(), conn, "CancelReason", new(emptypb.Empty), reason); err != nil {
+					t.Fatalf("err: %s", err)
+				}
+				if reason.Value == tc.want || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if reason.Value != tc.want {
+				t.Fatalf("plugin saw cancel reason %q, want %q", reason.Value, tc.want)
+			}
+		})
+	}
+}
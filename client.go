@@ -7,6 +7,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"hash"
@@ -16,6 +17,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -26,6 +29,7 @@ import (
 	"github.com/kform-dev/plugin/runner"
 	"github.com/henderiw/logger/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 )
 
 // If this is 1, then we've called CleanupClients. This can be used
@@ -60,6 +64,15 @@ var (
 	// ErrSecureConfigAndReattach is returned when both Reattach and
 	// SecureConfig are set.
 	ErrSecureConfigAndReattach = errors.New("only one of Reattach or SecureConfig can be set")
+
+	// ErrConcurrentCheckUnsupported is returned by Start when
+	// SecureConfig.Concurrent is set on a platform that can't gate the
+	// plugin process.
+	ErrConcurrentCheckUnsupported = errors.New("concurrent checksum verification is not supported on this platform")
+
+	// ErrSandboxAndReattach is returned when both Reattach and Sandbox are
+	// set.
+	ErrSandboxAndReattach = errors.New("only one of Reattach or Sandbox can be set")
 )
 
 // Client handles the lifecycle of a plugin application. It launches
@@ -86,6 +99,59 @@ type Client struct {
 	negotiatedVersion int
 	negotiatedPlugins PluginSet
 
+	// startTime is the time the plugin process was started.
+	startTime time.Time
+
+	// restartCount is the number of times the plugin has been restarted.
+	restartCount int
+
+	// restartable is the client returned by Client when AutoRestart is
+	// set, and shared the one returned with ShareConnections for the
+	// current connection, so that Client keeps returning the same one.
+	restartable *restartableClient
+	shared      *SharedConnClient
+
+	// extractedDir is the temporary directory holding the decompressed
+	// plugin binary, if ClientConfig.CompressedBinary is set.
+	extractedDir string
+
+	// singleton is the lock held while the plugin runs, if
+	// ClientConfig.Singleton is set.
+	singleton *singletonLock
+
+	// killing is set once Kill was called, so an exit is not mistaken for
+	// a crash to recover from with AutoRestart.
+	killing bool
+
+	// crashes are the recent unexpected exits of the plugin, counted
+	// against RestartPolicy.CrashLoopLimit.
+	crashes []crashLoopExit
+
+	// crashLooping is set once the plugin crashed more often than allowed
+	// by RestartPolicy.CrashLoopLimit.
+	crashLooping bool
+
+	// restarting is set while autoRestart relaunches the plugin, so the
+	// exit of a failed attempt doesn't start another restart loop.
+	restarting bool
+
+	// cmdTemplate is an unstarted copy of ClientConfig.Cmd as it was before
+	// Start modified it, used to launch the plugin again.
+	cmdTemplate *exec.Cmd
+
+	// cmdLine and cmdEnv are snapshots of the argv and the redacted
+	// environment the plugin was launched with.
+	cmdLine []string
+	cmdEnv  []string
+
+	// pluginLibraryVersion is the LibraryVersion advertised by the plugin in
+	// its handshake. It is empty for plugins that don't advertise it.
+	pluginLibraryVersion string
+
+	// pluginAddrs holds the address of each plugin of a process started with
+	// ClientConfig.MultiplexHandshakes.
+	pluginAddrs map[string]net.Addr
+
 	// clientWaitGroup is used to manage the lifecycle of the plugin management
 	// goroutines.
 	clientWaitGroup sync.WaitGroup
@@ -99,6 +165,39 @@ type Client struct {
 	processKilled bool
 
 	unixSocketCfg UnixSocketConfig
+
+	// startupDiag is the last startup diagnostic reported by the plugin on
+	// stderr. It is written by logStderr while Start holds the lock, so it
+	// is not guarded by m.
+	startupDiag atomic.Pointer[StartupDiagnostic]
+
+	// serverCert is the certificate the plugin presented in its AutoMTLS
+	// handshake. It is read by the dialer, which runs without the lock.
+	serverCert atomic.Pointer[x509.Certificate]
+
+	// stderrLogger is the logger the plugin's stderr is logged with. It is
+	// tagged with the negotiated protocol once the handshake completed.
+	stderrLogger atomic.Pointer[slog.Logger]
+
+	// events writes the event stream of ClientConfig.EventWriter. It is nil
+	// if no EventWriter is configured.
+	events *slog.Logger
+
+	// startDone is set once Start returned, to stop forwarding stderr to
+	// ClientConfig.OnStartupOutput.
+	startDone atomic.Bool
+
+	// idle kills the plugin once it has not been used for
+	// ClientConfig.IdleTimeout. It is nil if no IdleTimeout is configured.
+	idle *idleTracker
+
+	// startupFailed is signalled when the plugin reports a startup
+	// diagnostic, so Start can fail without waiting for the plugin to exit.
+	startupFailed chan struct{}
+
+	// configFingerprint is the fingerprint of the config taken by NewClient
+	// if ClientConfig.DetectConfigMutation is set.
+	configFingerprint []byte
 }
 
 // NegotiatedVersion returns the protocol version negotiated with the server.
@@ -107,6 +206,97 @@ func (c *Client) NegotiatedVersion() int {
 	return c.negotiatedVersion
 }
 
+// NegotiatedPlugins returns a copy of the plugin set compatible with the
+// negotiated protocol version, i.e. the plugins that can be dispensed. It
+// returns nil before Start() has negotiated a version.
+func (c *Client) NegotiatedPlugins() PluginSet {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.negotiatedPlugins == nil {
+		return nil
+	}
+	plugins := make(PluginSet, len(c.negotiatedPlugins))
+	for name, p := range c.negotiatedPlugins {
+		plugins[name] = p
+	}
+	return plugins
+}
+
+// Context returns a context that is cancelled once the plugin exited, to
+// scope work to the lifetime of the plugin. It is only valid after Start()
+// has succeeded; before that, the returned context is already cancelled.
+func (c *Client) Context() context.Context {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.doneCtx == nil {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		cancel(errors.New("plugin not started"))
+		return ctx
+	}
+	return c.doneCtx
+}
+
+// PluginLibraryVersion returns the version of this library that the plugin
+// was built with, as advertised in its handshake. It returns an empty string
+// if the plugin didn't advertise a version or Start() hasn't been called.
+func (c *Client) PluginLibraryVersion() string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.pluginLibraryVersion
+}
+
+// CommandLine returns the argv the plugin was launched with, including any
+// wrapper command. For a RunnerFunc runner, it is the command given to the
+// runner, unless the runner implements runner.CommandReporter. It returns
+// nil if Start() hasn't launched a plugin.
+func (c *Client) CommandLine() []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return append([]string(nil), c.cmdLine...)
+}
+
+// CommandEnv returns the environment the plugin was launched with, in
+// "key=value" form. The value of the magic cookie and of any variable listed
+// in ClientConfig.RedactEnv is replaced with "<redacted>". It returns nil if
+// Start() hasn't launched a plugin.
+func (c *Client) CommandEnv() []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return append([]string(nil), c.cmdEnv...)
+}
+
+// snapshotCommand records the argv and redacted environment the plugin is
+// run with for CommandLine and CommandEnv: those reported by r, or else
+// those of cmd.
+func (c *Client) snapshotCommand(cmd *exec.Cmd, r runner.Runner) {
+	redact := map[string]bool{c.config.MagicCookieKey: true}
+	for _, k := range c.config.RedactEnv {
+		redact[k] = true
+	}
+
+	args, env := cmd.Args, cmd.Env
+	if len(args) == 0 {
+		args = []string{cmd.Path}
+	}
+	if cr, ok := r.(runner.CommandReporter); ok {
+		args, env = cr.Command()
+	}
+
+	c.cmdLine = append([]string(nil), args...)
+	c.cmdEnv = make([]string, 0, len(env))
+	for _, kv := range env {
+		if k, _, ok := strings.Cut(kv, "="); ok && redact[k] {
+			kv = k + "=<redacted>"
+		}
+		c.cmdEnv = append(c.cmdEnv, kv)
+	}
+}
+
 // ID returns a unique ID for the running plugin. By default this is the process
 // ID (pid), but it could take other forms if RunnerFunc was provided.
 func (c *Client) ID() string {
@@ -120,6 +310,15 @@ func (c *Client) ID() string {
 	return ""
 }
 
+// Exited tells whether the underlying process has exited. It does not kill
+// the plugin, so it can be polled, e.g. to restart plugins that died.
+func (c *Client) Exited() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.exited
+}
+
 // ClientConfig is the configuration used to initialize a new
 // plugin client. After being used to initialize a plugin client,
 // that configuration must not be modified again.
@@ -143,20 +342,50 @@ type ClientConfig struct {
 	Cmd      *exec.Cmd
 	Reattach *ReattachConfig
 
+	// Discovery is configuration for connecting to a remote plugin whose
+	// address is looked up by service name, instead of setting Cmd or
+	// Reattach. The address is resolved again whenever the connection is
+	// re-established. The connection is secured with TLSConfig; AutoMTLS
+	// and the options for launching a plugin can not be used with it.
+	Discovery *DiscoveryConfig
+
 	// RunnerFunc allows consumers to provide their own implementation of
 	// runner.Runner and control the context within which a plugin is executed.
 	// The cmd argument will have been copied from the config and populated with
 	// environment variables that a go-plugin server expects to read such as
-	// AutoMTLS certs and the magic cookie key.
+	// AutoMTLS certs and the magic cookie key. Runners that can't provide the
+	// plugin's stdout must implement runner.ReadinessReporter.
 	RunnerFunc func(l *slog.Logger, cmd *exec.Cmd, tmpDir string) (runner.Runner, error)
 
+	// CompressedBinary is configuration for running a plugin shipped as a
+	// compressed executable, with Cmd providing its arguments. It can not be
+	// used with Reattach.
+	CompressedBinary *CompressedBinary
+
 	// SecureConfig is configuration for verifying the integrity of the
 	// executable. It can not be used with Reattach.
 	SecureConfig *SecureConfig
 
+	// Provenance is configuration for verifying a provenance attestation of
+	// the plugin binary before running it. It can not be used with Reattach.
+	Provenance *ProvenanceConfig
+
+	// Sandbox is configuration for isolating the plugin subprocess from the
+	// host. It is only supported on Linux, and can not be used with Reattach.
+	Sandbox *SandboxConfig
+
 	// TLSConfig is used to enable TLS on the RPC client.
 	TLSConfig *tls.Config
 
+	// BrokerTLSConfig is used for connections brokered through GRPCBroker.
+	// If nil, brokered connections use TLSConfig, or the configuration
+	// negotiated by AutoMTLS when it is enabled.
+	//
+	// AutoMTLS only negotiates certificates for the main connection, so when
+	// this is set the plugin must serve with a matching
+	// ServeConfig.BrokerTLSProvider.
+	BrokerTLSConfig *tls.Config
+
 	// Managed represents if the client should be managed by the
 	// plugin package or not. If true, then by calling CleanupClients,
 	// it will automatically be cleaned up. Otherwise, the client
@@ -164,15 +393,232 @@ type ClientConfig struct {
 	// clients. By default the client is _not_ managed.
 	Managed bool
 
+	// ShutdownPriority orders the shutdown of managed clients by
+	// CleanupClients: clients are killed in ascending order of priority,
+	// those with the same priority in parallel, and each tier only once the
+	// previous one has shut down. A plugin others depend on, e.g. one
+	// holding a lock, should have a higher priority than its dependents.
+	ShutdownPriority int
+
+	// Singleton makes Start fail with ErrPluginAlreadyRunning if another
+	// instance of the plugin binary is running on the host, for plugins that
+	// hold an exclusive resource. It is backed by a file lock on a lock file
+	// derived from the binary path in the temp directory, which is held
+	// while the plugin runs. It requires Cmd and is not supported on
+	// Windows.
+	Singleton bool
+
+	// DetectConfigMutation makes Start fail with ErrConfigMutated if the
+	// config was modified after it was passed to NewClient, e.g. because it
+	// is shared between clients. It is meant for debugging, since it hashes
+	// the config twice.
+	DetectConfigMutation bool
+
 	// The minimum and maximum port to use for communicating with
 	// the subprocess. If not set, this defaults to 10,000 and 25,000
-	// respectively.
+	// respectively. Both must be between 1 and 65535, with MinPort no
+	// greater than MaxPort. If every port in the range is in use, Start
+	// fails with an error matching ErrNoAvailablePort.
 	MinPort, MaxPort uint
 
+	// FixedPort, if set, makes the plugin listen on exactly this TCP port
+	// instead of one in the MinPort-MaxPort range, e.g. to match firewall
+	// rules. The plugin listens on TCP even on platforms that default to
+	// Unix sockets, and Start fails if the port is not available.
+	FixedPort uint
+
+	// ForceTCP makes the plugin listen on TCP even on platforms that
+	// default to Unix sockets. With RunnerFunc, Start then doesn't create a
+	// temporary directory for the sockets, e.g. for filesystems without a
+	// writable one, and RunnerFunc is passed an empty socket directory.
+	// It can't be combined with UnixSocketConfig.FixedName.
+	ForceTCP bool
+
+	// BindInterface restricts the plugin's TCP listener to a network
+	// interface. It is either an interface name, such as "eth0", in which
+	// case the first IPv4 address of the interface is used, or an IP address
+	// assigned to one of the host's interfaces. If not set, the plugin binds
+	// to 127.0.0.1. It has no effect on plugins listening on Unix sockets.
+	BindInterface string
+
 	// StartTimeout is the timeout to wait for the plugin to say it
 	// has started successfully.
 	StartTimeout time.Duration
 
+	// HandshakeSettleTime is how long Start keeps reading the plugin's
+	// stdout after a handshake line, for plugins that rebind and print a new
+	// handshake during a multi-stage startup. Each handshake read within this
+	// window restarts it, and only the last handshake read before the window
+	// (or StartTimeout) elapses is used. If zero, the first handshake is used.
+	HandshakeSettleTime time.Duration
+
+	// PartialHandshakeTimeout is how long the plugin may leave a line of
+	// stdout unfinished before it completed the handshake. Once it elapses,
+	// Start fails with ErrPartialHandshake and the bytes written so far,
+	// to tell a plugin that hung while writing its handshake from one that
+	// never wrote anything. If zero, partial lines are not checked for, as
+	// some plugins print unterminated output before their handshake.
+	PartialHandshakeTimeout time.Duration
+
+	// HandshakePrefix, if set, is a marker the plugin is asked to start its
+	// handshake line with, e.g. "plugin-handshake:", so that only lines
+	// starting with it are taken for handshakes, for plugins that print
+	// pipe-delimited data to stdout before the handshake. Other lines are
+	// written to SyncStdout. A plugin built with a version of this package
+	// that doesn't support it is still recognized if it prints its
+	// handshake as the first line.
+	HandshakePrefix string
+
+	// MultiplexHandshakes is set for plugin processes that serve each of
+	// several plugins on its own listener and print one handshake line per
+	// plugin. Each line names the plugin it is for in an extra field after
+	// the library version. Start waits until a handshake was read for every
+	// plugin of the negotiated set, and the ClientProtocol returned by Client
+	// dispenses each plugin over the connection of its handshake.
+	// HandshakeSettleTime is ignored.
+	MultiplexHandshakes bool
+
+	// ShareConnections, with MultiplexHandshakes, dispenses the plugins a
+	// consolidated plugin process serves on the same address over a single
+	// gRPC connection instead of a connection per plugin. Client then
+	// returns a *SharedConnClient, unless AutoRestart is set.
+	ShareConnections bool
+
+	// EventWriter, if set, receives a machine readable stream of the
+	// plugin's lifecycle events and parsed log lines, as newline delimited
+	// JSON objects. Each object holds the time, level, msg, plugin name and
+	// pid, an event field that is either "lifecycle" or "log", and the
+	// attributes of the event. Writes are serialized.
+	EventWriter io.Writer
+
+	// AutoRestart, if set, relaunches the plugin when it exits without Kill
+	// being called, retrying as configured by the policy. The plugin is
+	// renegotiated and the ClientProtocol returned by Client reconnects to
+	// the new process transparently, but interfaces dispensed from the
+	// crashed process keep using its closed connection and must be
+	// dispensed again. It is only supported for plugins started from Cmd or
+	// RunnerFunc.
+	AutoRestart *RestartPolicy
+
+	// OnCrashLoop, if set, is called with the exit errors of the crashes
+	// that made the plugin cross RestartPolicy.CrashLoopLimit, oldest first,
+	// once it is no longer restarted. Errors are nil for crashes where the
+	// plugin exited successfully.
+	OnCrashLoop func(errs []error)
+
+	// OnLifecycleEvent, if set, is called when the plugin started, exited
+	// or was restarted, e.g. to record lifecycle metrics, see the
+	// otelmetrics package. It must not block.
+	OnLifecycleEvent func(LifecycleEvent)
+
+	// OnExit, if set, is called when the plugin process exited, with the
+	// error it exited with, or nil if it exited cleanly, e.g. to mark the
+	// plugin unhealthy and respawn it after a crash. It is also called when
+	// the plugin exits because of Kill. It runs before Kill returns, so it
+	// must not call Kill itself, except from a new goroutine.
+	OnExit func(err error)
+
+	// PostHandshakeWait, if set, is called by Start with the address the
+	// plugin advertised in its handshake, before the client connects to it,
+	// e.g. to wait until the port mapping of a containerized plugin is set
+	// up. ctx is done when StartTimeout elapsed or the plugin exited. If it
+	// returns an error, Start fails and the plugin is killed.
+	PostHandshakeWait func(ctx context.Context, addr net.Addr) error
+
+	// OnAddressResolved, if set, is called by Start with the address of the
+	// plugin it launched, once the handshake completed and before Start
+	// returns, e.g. to register the endpoint in a service discovery system.
+	// Unlike ReattachConfig, it fires inline during startup. It is called
+	// with the client locked, so it must not call methods of the Client.
+	OnAddressResolved func(addr net.Addr)
+
+	// OnVersionNegotiated, if set, is called with the protocol version
+	// negotiated with the plugin, which is the version its handshake
+	// advertised, and the sorted names of the plugins of that version's
+	// set, e.g. to record which protocol versions are in use across a
+	// fleet. It is called for every handshake read, and on reattach, with
+	// the client locked, so it must not call methods of the Client.
+	OnVersionNegotiated func(version int, plugins []string)
+
+	// StderrJSONHandler, if set, is called with every line of plugin
+	// stderr that parses as a JSON log entry, instead of logging it with the
+	// plugin's logger, e.g. to forward plugin logs to another telemetry
+	// pipeline. The line is still written to Stderr. It is called from the
+	// goroutine reading stderr, and must not block.
+	StderrJSONHandler func(entry *LogEntry)
+
+	// MaxStderrLineSize is the longest line of plugin stderr that is read
+	// as a whole, e.g. to parse JSON log lines carrying large payloads.
+	// Longer lines are split and logged without being parsed. Defaults to
+	// 64KB.
+	MaxStderrLineSize int
+
+	// StdoutReaderSize is the size of the buffer plugin stdout is read
+	// into while waiting for the handshake, and so the longest line of
+	// stdout that can be read, e.g. for plugins that print large output
+	// before the handshake. Longer lines fail Start. Defaults to 64KB.
+	StdoutReaderSize int
+
+	// StderrLevelPrefixes maps the prefixes of non-JSON lines of plugin
+	// stderr to the level they are logged at, e.g. "WARNING:" and
+	// "CRITICAL:" for plugins using Python's logging module. When several
+	// prefixes match, the longest one wins, and lines matching none are
+	// logged at Debug. It replaces the default prefixes, "[TRACE]",
+	// "[DEBUG]", "[INFO]", "[WARN]" and "[ERROR]", when set.
+	StderrLevelPrefixes map[string]slog.Level
+
+	// OnStartupOutput, if set, is called with each line the plugin writes to
+	// stderr until Start returns, e.g. to show the progress of a slow
+	// starting plugin. It is called from the goroutine reading stderr, which
+	// is separate from the handshake, but lines are not logged until it
+	// returns.
+	OnStartupOutput func(line string)
+
+	// GracefulShutdownTimeout is how long Kill waits for the plugin to exit
+	// after asking it to shut down, before force killing it. Plugins that
+	// flush large caches on shutdown may need longer than the default of 2
+	// seconds.
+	GracefulShutdownTimeout time.Duration
+
+	// OnGracefulTimeout, if set, is called by Kill when the plugin was asked
+	// to shut down but did not exit in time and is about to be force killed,
+	// e.g. to count plugins with shutdown bugs.
+	OnGracefulTimeout func()
+
+	// OnBackpressure, if set, is called when the plugin reports that it is
+	// overloaded with SetBackpressure, so the host can slow down or stop
+	// sending new requests. resume is closed once the plugin recovered. It
+	// is called in its own goroutine, once per overload.
+	OnBackpressure func(resume <-chan struct{})
+
+	// IdleTimeout, if set, kills the plugin once no RPC to it has been in
+	// flight for this long, to reclaim the resources of plugins kept warm
+	// but not used. Calls to the plugin's health service, such as Ping, do
+	// not count as using it.
+	IdleTimeout time.Duration
+
+	// FDWarnThreshold, if set, makes the client periodically count the file
+	// descriptors the plugin has open and log a warning once there are more
+	// than this many, to catch descriptor leaks before the plugin runs into
+	// its ulimit. It is only supported on Linux, see Client.FDCount.
+	FDWarnThreshold int
+
+	// ForceKillSignal, if set, is the signal Kill sends to the plugin when it
+	// did not shut down gracefully, instead of killing it outright, e.g.
+	// SIGQUIT to make a Go plugin dump its goroutines before dying. The
+	// plugin should not expect to be able to clean up after it. If the
+	// plugin has not exited shortly after, or the runner can't send signals,
+	// it is killed anyway.
+	ForceKillSignal os.Signal
+
+	// MessageMAC adds a MAC to every unary RPC request and verifies the MAC
+	// of every response, rejecting messages that were tampered with. The MAC
+	// is keyed from the magic cookie, so it only detects tampering by
+	// parties that don't know the cookie. It is lighter than TLS but doesn't
+	// provide confidentiality or replay protection, and doesn't cover
+	// streams. The plugin must set ServeConfig.MessageMAC too.
+	MessageMAC bool
+
 	// If non-nil, then the stderr of the client will be written to here
 	// (as well as the log). This is the original os.Stderr of the subprocess.
 	// This isn't the output of synced stderr.
@@ -212,15 +658,103 @@ type ClientConfig struct {
 	// You cannot Reattach to a server with this option enabled.
 	AutoMTLS bool
 
+	// AutoMTLSRootCAs, if set, is the pool of CAs the server certificate
+	// negotiated by AutoMTLS is added to, instead of an empty pool, so the
+	// connection also trusts an existing organizational CA. The pool is
+	// cloned and not modified.
+	AutoMTLSRootCAs *x509.CertPool
+
+	// AutoMTLSCertConfig, if set, sets the key type and validity of the
+	// certificates generated by AutoMTLS, on both the client and the plugin
+	// side. Plugins built with older versions of this library ignore it.
+	AutoMTLSCertConfig *AutoMTLSCertConfig
+
+	// ExpectedSPIFFEID, if set, requires the certificate presented by the
+	// plugin to carry a URI SAN with this SPIFFE ID, e.g.
+	// "spiffe://example.org/plugins/kv". An ID without a path, e.g.
+	// "spiffe://example.org", accepts any ID of that trust domain. It is
+	// checked on every connection to the plugin, including brokered ones,
+	// and requires TLSConfig to be set, since the certificates generated by
+	// AutoMTLS carry no SPIFFE ID.
+	ExpectedSPIFFEID string
+
 	// GRPCDialOptions allows plugin users to pass custom grpc.DialOption
 	// to create gRPC connections. This only affects plugins using the gRPC
 	// protocol.
 	GRPCDialOptions []grpc.DialOption
 
+	// GRPCDialTimeout, if set, bounds how long Client waits for the gRPC
+	// connection to the plugin to be established, including the TLS
+	// handshake, after the plugin printed its address. Otherwise Client
+	// returns without waiting, and a plugin that doesn't complete the
+	// connection makes the first calls hang instead.
+	GRPCDialTimeout time.Duration
+
+	// GRPCKeepaliveParams, if set, enables gRPC keepalive pings on the
+	// connection to the plugin, e.g. to keep long-lived streams from being
+	// dropped by proxies with an idle timeout, or to detect that they were.
+	// The plugin's server must allow pings this frequent, or it closes the
+	// connection.
+	GRPCKeepaliveParams *keepalive.ClientParameters
+
+	// GRPCMaxRecvMsgSize and GRPCMaxSendMsgSize, if set, are the largest
+	// messages in bytes the client receives from and sends to the plugin.
+	// They default to 2GB. The plugin applies its own limits, see
+	// ServeConfig.MaxRecvMsgSize; messages larger than the 4MB gRPC
+	// default need to be allowed there too.
+	GRPCMaxRecvMsgSize int
+	GRPCMaxSendMsgSize int
+
+	// GRPCUnaryInterceptors and GRPCStreamInterceptors are chained, in
+	// order, on the gRPC connection to the plugin, e.g. to add tracing
+	// spans or request IDs to the calls. They run before the interceptors
+	// of the client itself. Connections opened with the GRPCBroker are not
+	// intercepted.
+	GRPCUnaryInterceptors  []grpc.UnaryClientInterceptor
+	GRPCStreamInterceptors []grpc.StreamClientInterceptor
+
+	// CompressThreshold, if positive, enables compression of the gRPC
+	// messages of at least this many bytes, in both directions. Smaller
+	// messages are sent uncompressed, to avoid the cost of compressing
+	// small control messages. The plugin must be built with a version of
+	// this package supporting it. The compressor is registered for the
+	// whole process, so clients setting different thresholds share the one
+	// of the client that connected last.
+	CompressThreshold int
+
+	// ServiceConfigJSON is a gRPC service config in JSON, used as the
+	// default service config of the connection to the plugin, e.g. to
+	// configure per-method retry policies and timeouts. See
+	// https://github.com/grpc/grpc/blob/master/doc/service_config.md. An
+	// invalid service config makes Client fail.
+	ServiceConfigJSON string
+
+	// RetryBudget, if set, caps the retries of the retry policies of
+	// ServiceConfigJSON across all the calls to the plugin, to avoid retry
+	// storms while it is failing. It sets the retryThrottling of the
+	// service config, which must not set it itself.
+	RetryBudget *RetryBudget
+
 	// SkipHostEnv allows plugins to run without inheriting the parent process'
 	// environment variables.
 	SkipHostEnv bool
 
+	// EnvMergePolicy controls how Cmd.Env is merged with the host
+	// environment and the variables set for the plugin. It defaults to
+	// EnvMergeAppend.
+	EnvMergePolicy EnvMergePolicy
+
+	// RedactEnv lists environment variables whose values are redacted from
+	// the environment returned by Client.CommandEnv. The magic cookie is
+	// always redacted.
+	RedactEnv []string
+
+	// DetachFromSignals starts the plugin in its own process group, so that
+	// signals delivered to the host's process group (e.g. SIGINT when the
+	// user presses Ctrl-C in a terminal) don't reach the plugin. The host is
+	// then responsible for terminating the plugin by calling Kill.
+	DetachFromSignals bool
+
 	// UnixSocketConfig configures additional options for any Unix sockets
 	// that are created. Not normally required. Not supported on Windows.
 	UnixSocketConfig *UnixSocketConfig
@@ -237,10 +771,24 @@ type UnixSocketConfig struct {
 	// not set, defaults to the directory chosen by os.MkdirTemp.
 	TempDir string
 
+	// FixedName, if set, is the name of the socket the plugin listens on,
+	// created directly in TempDir, or the default temporary directory, so
+	// that external tools such as monitoring can find it at a predictable
+	// path. Start fails if another plugin is listening on it, so only one
+	// plugin can run with a given name at a time. The path must fit the
+	// limit of unix socket paths of about 100 bytes. Sockets opened with
+	// the GRPCBroker still get random names. It is not supported with
+	// RunnerFunc.
+	FixedName string
+
 	// The directory to create Unix sockets in. Internally created and managed
 	// by go-plugin and deleted when the plugin is killed. Will be created
 	// inside TempDir if specified.
 	socketDir string
+
+	// socketPath is the path the plugin server listens on, set from
+	// EnvUnixSocketPath.
+	socketPath string
 }
 
 func netAddrDialer(addr net.Addr) func(string, time.Duration) (net.Conn, error) {
@@ -292,17 +840,111 @@ type ReattachConfig struct {
 type SecureConfig struct {
 	Checksum []byte
 	Hash     hash.Hash
+
+	// HashFunc, if set, is used instead of Hash to create a fresh hasher
+	// for every Check, so a SecureConfig can be shared between clients.
+	HashFunc func() hash.Hash
+
+	// Concurrent verifies the checksum while the plugin is being launched,
+	// instead of before, which hides the hashing time of large binaries.
+	// The plugin process is stopped as soon as it is exec'd, before it runs
+	// any of its code, and is only let go once the checksum matched. It is
+	// killed otherwise.
+	//
+	// The process is gated by tracing it with ptrace, so this is only
+	// supported on Linux, for plugins launched from ClientConfig.Cmd, and
+	// fails if the host is not allowed to trace its children, or if the
+	// plugin is already traced, e.g. by a debugger.
+	Concurrent bool
+}
+
+// NewSecureConfig hashes the file at filePath with a hasher created by h and
+// returns a SecureConfig that verifies the file against that checksum. It is
+// meant for tooling that computes the checksum to pin when installing a
+// plugin.
+func NewSecureConfig(filePath string, h func() hash.Hash) (*SecureConfig, error) {
+	if h == nil {
+		return nil, ErrSecureConfigNoHash
+	}
+
+	sum, err := ComputeChecksum(filePath, h())
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecureConfig{
+		Checksum: sum,
+		HashFunc: h,
+	}, nil
+}
+
+// NewSecureConfigFromHex returns a SecureConfig verifying files against the
+// checksum hexsum, as printed by tools such as sha256sum, computed with h.
+func NewSecureConfigFromHex(hexsum string, h hash.Hash) (*SecureConfig, error) {
+	hexsum = strings.TrimSpace(hexsum)
+	if hexsum == "" {
+		return nil, ErrSecureConfigNoChecksum
+	}
+
+	sum, err := hex.DecodeString(hexsum)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex checksum: %w", err)
+	}
+	return newSecureConfigChecksum(sum, h)
+}
+
+// NewSecureConfigFromBase64 returns a SecureConfig verifying files against
+// the standard base64 encoded checksum b64sum, computed with h.
+func NewSecureConfigFromBase64(b64sum string, h hash.Hash) (*SecureConfig, error) {
+	b64sum = strings.TrimSpace(b64sum)
+	if b64sum == "" {
+		return nil, ErrSecureConfigNoChecksum
+	}
+
+	sum, err := base64.StdEncoding.DecodeString(b64sum)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 checksum: %w", err)
+	}
+	return newSecureConfigChecksum(sum, h)
+}
+
+// newSecureConfigChecksum returns a SecureConfig for a decoded checksum,
+// checking that it has the size of the checksums computed by h.
+func newSecureConfigChecksum(sum []byte, h hash.Hash) (*SecureConfig, error) {
+	if h == nil {
+		return nil, ErrSecureConfigNoHash
+	}
+	if len(sum) != h.Size() {
+		return nil, fmt.Errorf("checksum is %d bytes long, but the hash produces %d bytes", len(sum), h.Size())
+	}
+	return &SecureConfig{
+		Checksum: sum,
+		Hash:     h,
+	}, nil
+}
+
+// ComputeChecksum returns the checksum of the file at filePath computed with
+// h, in the form expected by SecureConfig.Checksum. h is reset first, so it
+// doesn't need to be fresh.
+func ComputeChecksum(filePath string, h hash.Hash) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	h.Reset()
+	if _, err := io.Copy(h, file); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
 }
 
 // Check takes the filepath to an executable and returns true if the checksum of
 // the file matches the checksum provided in the SecureConfig.
 func (s *SecureConfig) Check(filePath string) (bool, error) {
-	if len(s.Checksum) == 0 {
-		return false, ErrSecureConfigNoChecksum
-	}
-
-	if s.Hash == nil {
-		return false, ErrSecureConfigNoHash
+	if err := s.validate(); err != nil {
+		return false, err
 	}
 
 	file, err := os.Open(filePath)
@@ -311,40 +953,109 @@ func (s *SecureConfig) Check(filePath string) (bool, error) {
 	}
 	defer file.Close()
 
-	_, err = io.Copy(s.Hash, file)
-	if err != nil {
+	return s.CheckReader(file)
+}
+
+// CheckReader returns true if the checksum of the data read from r matches
+// the checksum provided in the SecureConfig. It can be used to verify a
+// binary that is not in a file yet, e.g. one embedded with embed.FS before
+// writing it to disk.
+func (s *SecureConfig) CheckReader(r io.Reader) (bool, error) {
+	if err := s.validate(); err != nil {
+		return false, err
+	}
+
+	h := s.Hash
+	if s.HashFunc != nil {
+		h = s.HashFunc()
+	} else {
+		// The hash may hold the state of a previous Check, e.g. when a
+		// plugin is restarted.
+		h.Reset()
+	}
+	if _, err := io.Copy(h, r); err != nil {
 		return false, err
 	}
 
-	sum := s.Hash.Sum(nil)
+	sum := h.Sum(nil)
 
 	return subtle.ConstantTimeCompare(sum, s.Checksum) == 1, nil
 }
 
+// validate checks that the SecureConfig can verify checksums.
+func (s *SecureConfig) validate() error {
+	if len(s.Checksum) == 0 {
+		return ErrSecureConfigNoChecksum
+	}
+
+	if s.Hash == nil && s.HashFunc == nil {
+		return ErrSecureConfigNoHash
+	}
+	return nil
+}
+
+// checkAsync runs Check in the background and sends nil on the returned
+// channel if the checksum matched.
+func (s *SecureConfig) checkAsync(filePath string) <-chan error {
+	ch := make(chan error, 1)
+	go func() {
+		if ok, err := s.Check(filePath); err != nil {
+			ch <- fmt.Errorf("error verifying checksum: %s", err)
+		} else if !ok {
+			ch <- ErrChecksumsDoNotMatch
+		} else {
+			ch <- nil
+		}
+	}()
+	return ch
+}
+
 // This makes sure all the managed subprocesses are killed and properly
 // logged. This should be called before the parent process running the
 // plugins exits.
 //
 // This must only be called _once_.
 func CleanupClients() {
+	CleanupClientsContext(context.Background())
+}
+
+// CleanupClientsContext is like CleanupClients, but stops waiting for the
+// plugins once ctx is done, see Client.KillContext. It returns the errors of
+// all the clients that could not be killed.
+func CleanupClientsContext(ctx context.Context) error {
 	// Set the killed to true so that we don't get unexpected panics
 	atomic.StoreUint32(&Killed, 1)
 
-	// Kill all the managed clients in parallel and use a WaitGroup
-	// to wait for them all to finish up.
-	var wg sync.WaitGroup
 	managedClientsLock.Lock()
-	for _, client := range managedClients {
-		wg.Add(1)
-
-		go func(client *Client) {
-			client.Kill()
-			wg.Done()
-		}(client)
-	}
+	clients := append([]*Client(nil), managedClients...)
 	managedClientsLock.Unlock()
 
-	wg.Wait()
+	// Kill the managed clients by tier of ShutdownPriority. The clients of
+	// a tier are killed in parallel and use a WaitGroup to wait for them all
+	// to finish up.
+	sort.SliceStable(clients, func(i, j int) bool {
+		return clients[i].config.ShutdownPriority < clients[j].config.ShutdownPriority
+	})
+	errs := make([]error, len(clients))
+	for start := 0; start < len(clients); {
+		priority := clients[start].config.ShutdownPriority
+		end := start
+		var wg sync.WaitGroup
+		for ; end < len(clients) && clients[end].config.ShutdownPriority == priority; end++ {
+			wg.Add(1)
+
+			go func(i int, client *Client) {
+				defer wg.Done()
+				id := client.ID()
+				if err := client.KillContext(ctx); err != nil {
+					errs[i] = fmt.Errorf("plugin %s: %w", id, err)
+				}
+			}(end, clients[end])
+		}
+		wg.Wait()
+		start = end
+	}
+	return errors.Join(errs...)
 }
 
 // Creates a new plugin client which manages the lifecycle of an external
@@ -380,8 +1091,12 @@ func NewClient(config *ClientConfig) (c *Client) {
 	}
 
 	c = &Client{
-		config: config,
-		logger: config.Logger,
+		config:        config,
+		logger:        config.Logger,
+		startupFailed: make(chan struct{}, 1),
+	}
+	if config.DetectConfigMutation {
+		c.configFingerprint = configFingerprint(config)
 	}
 	if config.Managed {
 		managedClientsLock.Lock()
@@ -394,8 +1109,39 @@ func NewClient(config *ClientConfig) (c *Client) {
 
 // Client returns the protocol client for this connection.
 //
-// Subsequent calls to this will return the same client.
+// Subsequent calls to this will return the same client, until the plugin
+// is restarted or reconnected to. With AutoRestart, the client follows the
+// restarts, so it is the same one for the lifetime of the Client.
 func (c *Client) Client() (ClientProtocol, error) {
+	if c.config.AutoRestart != nil {
+		if _, err := c.Start(); err != nil {
+			return nil, err
+		}
+		c.m.Lock()
+		defer c.m.Unlock()
+		if c.restartable == nil {
+			c.restartable = &restartableClient{c: c}
+		}
+		return c.restartable, nil
+	}
+
+	client, err := c.protocolClient()
+	if err != nil {
+		return nil, err
+	}
+	if mc, ok := client.(*multiplexedClient); ok && c.config.ShareConnections {
+		c.m.Lock()
+		defer c.m.Unlock()
+		if c.shared == nil || c.shared.multiplexedClient != mc {
+			c.shared = &SharedConnClient{multiplexedClient: mc}
+		}
+		return c.shared, nil
+	}
+	return client, nil
+}
+
+// protocolClient returns the protocol client of the current plugin process.
+func (c *Client) protocolClient() (ClientProtocol, error) {
 	_, err := c.Start()
 	if err != nil {
 		return nil, err
@@ -408,7 +1154,15 @@ func (c *Client) Client() (ClientProtocol, error) {
 		return c.client, nil
 	}
 
-	c.client, err = newGRPCClient(c.doneCtx, c)
+	if err := c.checkServerCert(); err != nil {
+		return nil, err
+	}
+
+	if c.pluginAddrs != nil {
+		c.client, err = newMultiplexedClient(c.doneCtx, c)
+	} else {
+		c.client, err = newGRPCClient(c.doneCtx, c)
+	}
 
 	if err != nil {
 		c.client = nil
@@ -418,6 +1172,108 @@ func (c *Client) Client() (ClientProtocol, error) {
 	return c.client, nil
 }
 
+// GRPCConn returns the gRPC connection to the plugin, e.g. to call services
+// the plugin process serves besides its plugins over the same authenticated
+// connection. The connection is owned by the client and must not be closed.
+// It is replaced when the client reconnects, after Disconnect or a restart,
+// so it should not be kept for longer than needed. It fails for a process
+// started with ClientConfig.MultiplexHandshakes, which has a connection per
+// plugin, see SharedConnClient.Conn.
+func (c *Client) GRPCConn() (*grpc.ClientConn, error) {
+	client, err := c.protocolClient()
+	if err != nil {
+		return nil, err
+	}
+
+	grpcClient, ok := client.(*GRPCClient)
+	if !ok {
+		return nil, fmt.Errorf("plugin client %T has no single gRPC connection", client)
+	}
+	return grpcClient.Conn, nil
+}
+
+// Disconnect closes the RPC connection to the plugin without killing the
+// plugin process. The next call to Client will dial a new connection to the
+// same address.
+//
+// The broker of the closed connection is shut down with it, so plugins that
+// rely on GRPCBroker should not expect brokered streams to survive a
+// reconnect.
+//
+// This method can safely be called multiple times.
+func (c *Client) Disconnect() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.client == nil {
+		return nil
+	}
+
+	client := c.client
+	c.client = nil
+
+	if d, ok := client.(interface{ disconnect() error }); ok {
+		return d.disconnect()
+	}
+	return client.Close()
+}
+
+// flushWriter flushes w if it buffers its output, e.g. a *bufio.Writer or an
+// *os.File.
+func flushWriter(w io.Writer) {
+	switch w := w.(type) {
+	case interface{ Flush() error }:
+		w.Flush()
+	case interface{ Sync() error }:
+		w.Sync()
+	}
+}
+
+// forceKillWithSignal sends ClientConfig.ForceKillSignal to the plugin and
+// reports whether it exited within forceKillSignalTimeout. It gives up early
+// if ctx is done.
+func (c *Client) forceKillWithSignal(ctx context.Context, r runner.AttachedRunner) bool {
+	s, ok := r.(runner.Signaler)
+	if !ok {
+		c.logger.Debug("runner cannot send signals, using its Kill", "signal", c.config.ForceKillSignal)
+		return false
+	}
+	if err := s.Signal(c.config.ForceKillSignal); err != nil {
+		c.logger.Debug("error signalling plugin", "signal", c.config.ForceKillSignal, "error", err)
+		return false
+	}
+
+	select {
+	case <-c.doneCtx.Done():
+		return true
+	case <-time.After(forceKillSignalTimeout):
+		c.logger.Warn("plugin did not exit after force kill signal", "signal", c.config.ForceKillSignal)
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// defaultGracefulShutdownTimeout is how long Kill waits for the plugin to
+// exit gracefully when ClientConfig.GracefulShutdownTimeout is not set.
+const defaultGracefulShutdownTimeout = 2 * time.Second
+
+// gracefulShutdownTimeout returns how long Kill waits for a graceful exit.
+func (c *Client) gracefulShutdownTimeout() time.Duration {
+	if c.config.GracefulShutdownTimeout > 0 {
+		return c.config.GracefulShutdownTimeout
+	}
+	return defaultGracefulShutdownTimeout
+}
+
+// forceKillSignalTimeout bounds how long Kill waits for the plugin to exit
+// after sending ClientConfig.ForceKillSignal, before killing it outright.
+var forceKillSignalTimeout = 2 * time.Second
+
+// killCloseTimeout bounds how long Kill waits for the client connection to
+// close before force killing the plugin.
+var killCloseTimeout = 2 * time.Second
+
 // End the executing subprocess (if it is running) and perform any cleanup
 // tasks necessary such as capturing any remaining logs and so on.
 //
@@ -425,8 +1281,20 @@ func (c *Client) Client() (ClientProtocol, error) {
 //
 // This method can safely be called multiple times.
 func (c *Client) Kill() {
+	c.KillContext(context.Background())
+}
+
+// KillContext is like Kill, but stops waiting for the plugin once ctx is
+// done: the graceful shutdown is cut short and the plugin is force killed
+// right away. It returns an error if the plugin could not be killed, or if
+// ctx was done before the plugin exited and its output was copied.
+func (c *Client) KillContext(ctx context.Context) (err error) {
 	// Grab a lock to read some private fields.
 	c.m.Lock()
+	c.killing = true
+	if c.idle != nil {
+		c.idle.stop()
+	}
 	runner := c.runner
 	addr := c.address
 	hostSocketDir := c.unixSocketCfg.socketDir
@@ -434,12 +1302,39 @@ func (c *Client) Kill() {
 
 	// If there is no runner or ID, there is nothing to kill.
 	if runner == nil || runner.ID() == "" {
-		return
+		// A discovered plugin isn't ours to stop, but its connection is,
+		// and so is the context of the client.
+		if c.config.Discovery != nil {
+			err := c.Disconnect()
+			c.m.Lock()
+			if c.ctxCancel != nil {
+				c.ctxCancel()
+			}
+			c.m.Unlock()
+			return err
+		}
+		return nil
 	}
 
 	defer func() {
-		// Wait for the all client goroutines to finish.
-		c.clientWaitGroup.Wait()
+		// Wait for the all client goroutines to finish, which includes the
+		// ones copying the plugin's output, then flush what they wrote so
+		// callers see the complete output once Kill returns.
+		waited := make(chan struct{})
+		go func() {
+			c.clientWaitGroup.Wait()
+			close(waited)
+		}()
+		select {
+		case <-waited:
+			flushWriter(c.config.Stderr)
+			flushWriter(c.config.SyncStdout)
+			flushWriter(c.config.SyncStderr)
+		case <-ctx.Done():
+			if err == nil {
+				err = fmt.Errorf("plugin did not exit: %w", context.Cause(ctx))
+			}
+		}
 
 		if hostSocketDir != "" {
 			os.RemoveAll(hostSocketDir)
@@ -459,9 +1354,21 @@ func (c *Client) Kill() {
 	graceful := false
 	if addr != nil {
 		// Close the client to cleanly exit the process.
-		client, err := c.Client()
+		client, err := c.protocolClient()
 		if err == nil {
-			err = client.Close()
+			// Closing can block on a half-open connection, so don't let it
+			// hold up the force kill below.
+			closeCh := make(chan error, 1)
+			go func() {
+				closeCh <- client.Close()
+			}()
+			select {
+			case err = <-closeCh:
+			case <-time.After(killCloseTimeout):
+				err = fmt.Errorf("timed out after %s closing client", killCloseTimeout)
+			case <-ctx.Done():
+				err = fmt.Errorf("closing client: %w", context.Cause(ctx))
+			}
 
 			// If there is no error, then we attempt to wait for a graceful
 			// exit. If there was an error, we assume that graceful cleanup
@@ -484,20 +1391,35 @@ func (c *Client) Kill() {
 		select {
 		case <-c.doneCtx.Done():
 			c.logger.Debug("plugin exited")
-			return
-		case <-time.After(2 * time.Second):
+			return nil
+		case <-time.After(c.gracefulShutdownTimeout()):
+			if c.config.OnGracefulTimeout != nil {
+				c.config.OnGracefulTimeout()
+			}
+		case <-ctx.Done():
 		}
 	}
 
 	// If graceful exiting failed, just kill it
 	c.logger.Warn("plugin failed to exit gracefully")
-	if err := runner.Kill(context.Background()); err != nil {
+	c.event(slog.LevelWarn, "plugin killed")
+	if c.config.ForceKillSignal != nil && c.forceKillWithSignal(ctx, runner) {
+		c.m.Lock()
+		c.processKilled = true
+		c.m.Unlock()
+		return nil
+	}
+	// The plugin is killed even if ctx is done, so it doesn't outlive the
+	// host.
+	if err := runner.Kill(context.WithoutCancel(ctx)); err != nil {
 		c.logger.Debug("error killing plugin", "error", err)
+		return fmt.Errorf("error killing plugin: %w", err)
 	}
 
 	c.m.Lock()
 	c.processKilled = true
 	c.m.Unlock()
+	return nil
 }
 
 // Start the underlying subprocess, communicating with it to negotiate
@@ -512,10 +1434,18 @@ func (c *Client) Start() (addr net.Addr, err error) {
 
 	l := c.logger
 
+	if c.crashLooping {
+		return nil, ErrCrashLooping
+	}
+
 	if c.address != nil {
 		return c.address, nil
 	}
 
+	if err := c.checkConfigMutation(); err != nil {
+		return nil, err
+	}
+
 	// If one of cmd or reattach isn't set, then it is an error. We wrap
 	// this in a {} for scoping reasons, and hopeful that the escape
 	// analysis will pop the stack here.
@@ -530,63 +1460,220 @@ func (c *Client) Start() (addr net.Addr, err error) {
 		if c.config.RunnerFunc != nil {
 			mutuallyExclusiveOptions += 1
 		}
+		if c.config.Discovery != nil {
+			mutuallyExclusiveOptions += 1
+		}
 		if mutuallyExclusiveOptions != 1 {
-			return nil, fmt.Errorf("exactly one of Cmd, or Reattach, or RunnerFunc must be set")
+			return nil, fmt.Errorf("exactly one of Cmd, or Reattach, or RunnerFunc, or Discovery must be set")
 		}
 
 		if c.config.SecureConfig != nil && c.config.Reattach != nil {
 			return nil, ErrSecureConfigAndReattach
 		}
-	}
 
-	/*
-		if c.config.Reattach != nil {
-			return c.reattach()
+		if c.config.Sandbox != nil && c.config.Reattach != nil {
+			return nil, ErrSandboxAndReattach
 		}
-	*/
 
-	if c.config.VersionedPlugins == nil {
-		c.config.VersionedPlugins = make(map[int]PluginSet)
-	}
+		// The client creates these socket directories on the host, where a
+		// chrooted plugin can't reach them.
+		if c.config.Sandbox != nil && c.config.Sandbox.Chroot != "" && !c.config.ForceTCP {
+			if c.config.RunnerFunc != nil {
+				return nil, errors.New("SandboxConfig.Chroot requires ForceTCP with RunnerFunc")
+			}
+			if c.config.UnixSocketConfig != nil && c.config.UnixSocketConfig.FixedName != "" {
+				return nil, errors.New("SandboxConfig.Chroot is not supported with UnixSocketConfig.FixedName")
+			}
+		}
 
-	var versions []string
-	for v := range c.config.VersionedPlugins {
-		versions = append(versions, strconv.Itoa(v))
-	}
+		if c.config.Provenance != nil && c.config.Reattach != nil {
+			return nil, ErrProvenanceAndReattach
+		}
 
-	env := []string{
-		fmt.Sprintf("%s=%s", c.config.MagicCookieKey, c.config.MagicCookieValue),
-		fmt.Sprintf("PLUGIN_MIN_PORT=%d", c.config.MinPort),
-		fmt.Sprintf("PLUGIN_MAX_PORT=%d", c.config.MaxPort),
-		fmt.Sprintf("PLUGIN_PROTOCOL_VERSIONS=%s", strings.Join(versions, ",")),
-	}
+		if c.config.CompressedBinary != nil && c.config.Reattach != nil {
+			return nil, errors.New("only one of Reattach or CompressedBinary can be set")
+		}
 
-	cmd := c.config.Cmd
-	if cmd == nil {
-		// It's only possible to get here if RunnerFunc is non-nil, but we'll
-		// still use cmd as a spec to populate metadata for the external
+		if err := validateBrokerTLS(c.config.BrokerTLSConfig); err != nil {
+			return nil, err
+		}
+
+		if c.config.ExpectedSPIFFEID != "" {
+			if c.config.TLSConfig == nil {
+				return nil, errors.New("ExpectedSPIFFEID requires TLSConfig to be set")
+			}
+			if _, err := parseSPIFFEID(c.config.ExpectedSPIFFEID); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := validateVersionedPlugins(c.config.VersionedPlugins); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.config.Reattach != nil {
+		return c.reattach()
+	}
+
+	if c.config.Discovery != nil {
+		return c.discover()
+	}
+
+	if c.config.VersionedPlugins == nil {
+		c.config.VersionedPlugins = make(map[int]PluginSet)
+	}
+
+	var versions []string
+	for v := range c.config.VersionedPlugins {
+		versions = append(versions, strconv.Itoa(v))
+	}
+
+	if err := validatePortRange(c.config.MinPort, c.config.MaxPort); err != nil {
+		return nil, err
+	}
+
+	env := []string{
+		fmt.Sprintf("%s=%s", c.config.MagicCookieKey, c.config.MagicCookieValue),
+		fmt.Sprintf("PLUGIN_MIN_PORT=%d", c.config.MinPort),
+		fmt.Sprintf("PLUGIN_MAX_PORT=%d", c.config.MaxPort),
+		fmt.Sprintf("PLUGIN_PROTOCOL_VERSIONS=%s", strings.Join(versions, ",")),
+	}
+
+	if c.config.FixedPort != 0 {
+		if c.config.FixedPort > 65535 {
+			return nil, fmt.Errorf("invalid FixedPort %d", c.config.FixedPort)
+		}
+		env = append(env, fmt.Sprintf("%s=%d", EnvFixedPort, c.config.FixedPort))
+	}
+
+	if c.config.ForceTCP {
+		env = append(env, fmt.Sprintf("%s=true", EnvForceTCP))
+	}
+
+	if c.config.HandshakePrefix != "" {
+		if strings.ContainsAny(c.config.HandshakePrefix, "\r\n") {
+			return nil, errors.New("HandshakePrefix must not contain a newline")
+		}
+		env = append(env, fmt.Sprintf("%s=%s", EnvHandshakePrefix, c.config.HandshakePrefix))
+	}
+
+	if c.config.CompressThreshold > 0 {
+		env = append(env, fmt.Sprintf("%s=%d", EnvCompressThreshold, c.config.CompressThreshold))
+	}
+
+	if c.config.BindInterface != "" {
+		bindAddr, err := resolveBindAddress(c.config.BindInterface)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, fmt.Sprintf("%s=%s", EnvBindAddress, bindAddr))
+	}
+
+	cmd := c.config.Cmd
+	if cmd == nil {
+		// It's only possible to get here if RunnerFunc is non-nil, but we'll
+		// still use cmd as a spec to populate metadata for the external
 		// implementation to consume.
 		cmd = exec.Command("")
 	}
+	c.cmdTemplate = cloneCmd(cmd)
+
+	if c.config.Singleton {
+		if c.config.Cmd == nil {
+			return nil, errors.New("Singleton requires Cmd to be set")
+		}
+		lockPath := cmd.Path
+		if c.config.CompressedBinary != nil {
+			lockPath = c.config.CompressedBinary.Path
+		}
+		lock, err := acquireSingleton(lockPath)
+		if err != nil {
+			return nil, err
+		}
+		c.singleton = lock
+		defer func() {
+			// Once the plugin runs, the lock is released when it exits.
+			if err != nil {
+				lock.release()
+			}
+		}()
+	}
+
+	if c.config.CompressedBinary != nil {
+		if c.config.Cmd == nil {
+			return nil, errors.New("CompressedBinary requires Cmd to be set")
+		}
+		dir, path, err := c.config.CompressedBinary.extract()
+		if err != nil {
+			return nil, err
+		}
+		c.extractedDir = dir
+		defer func() {
+			// Once the plugin runs, the binary is removed when it exits.
+			if err != nil {
+				os.RemoveAll(dir)
+			}
+		}()
+		// The placeholder name given to exec.Command may not resolve.
+		cmd.Path, cmd.Err = path, nil
+		l.Debug("decompressed plugin binary", "path", path)
+	}
+
+	var hostEnv []string
 	if !c.config.SkipHostEnv {
-		cmd.Env = append(cmd.Env, os.Environ()...)
+		hostEnv = os.Environ()
+	}
+	cmd.Env, err = mergeEnv(c.config.EnvMergePolicy, cmd.Env, hostEnv, env)
+	if err != nil {
+		return nil, err
 	}
-	cmd.Env = append(cmd.Env, env...)
 	cmd.Stdin = os.Stdin
 
-	if c.config.SecureConfig != nil {
-		if ok, err := c.config.SecureConfig.Check(cmd.Path); err != nil {
+	if c.config.DetachFromSignals {
+		detachFromSignals(cmd)
+	}
+
+	// The binary that runs in a chroot is the one inside it, so that is the
+	// one verified.
+	binaryPath := cmd.Path
+	if c.config.Sandbox != nil && c.config.Sandbox.Chroot != "" {
+		binaryPath = filepath.Join(c.config.Sandbox.Chroot, cmd.Path)
+	}
+
+	if c.config.Provenance != nil {
+		if err := c.config.Provenance.Check(context.Background(), binaryPath); err != nil {
+			return nil, fmt.Errorf("error verifying provenance: %w", err)
+		}
+	}
+
+	var verified <-chan error
+	if c.config.SecureConfig != nil && c.config.SecureConfig.Concurrent {
+		if err := gateExec(cmd); err != nil {
+			return nil, err
+		}
+		verified = c.config.SecureConfig.checkAsync(binaryPath)
+	} else if c.config.SecureConfig != nil {
+		if ok, err := c.config.SecureConfig.Check(binaryPath); err != nil {
 			return nil, fmt.Errorf("error verifying checksum: %s", err)
 		} else if !ok {
 			return nil, ErrChecksumsDoNotMatch
 		}
 	}
 
+	// The sandbox may wrap the command, so it is applied after the checksum
+	// of the plugin binary itself has been verified.
+	if c.config.Sandbox != nil {
+		if err := applySandbox(cmd, c.config.Sandbox); err != nil {
+			return nil, fmt.Errorf("error configuring plugin sandbox: %w", err)
+		}
+	}
+
 	// Setup a temporary certificate for client/server mtls, and send the public
 	// certificate to the plugin.
 	if c.config.AutoMTLS {
 		c.logger.Info("configuring client automatic mTLS")
-		certPEM, keyPEM, err := generateCert()
+		certPEM, keyPEM, err := generateCert(c.config.AutoMTLSCertConfig)
 		if err != nil {
 			c.logger.Error("failed to generate client certificate", "error", err)
 			return nil, err
@@ -598,6 +1685,9 @@ func (c *Client) Start() (addr net.Addr, err error) {
 		}
 
 		cmd.Env = append(cmd.Env, fmt.Sprintf("PLUGIN_CLIENT_CERT=%s", certPEM))
+		if c.config.AutoMTLSCertConfig != nil {
+			cmd.Env = append(cmd.Env, c.config.AutoMTLSCertConfig.env()...)
+		}
 
 		c.config.TLSConfig = &tls.Config{
 			Certificates: []tls.Certificate{cert},
@@ -605,6 +1695,8 @@ func (c *Client) Start() (addr net.Addr, err error) {
 			MinVersion:   tls.VersionTLS12,
 			ServerName:   "localhost",
 		}
+		// The plugin presents a new certificate once started.
+		c.serverCert.Store(nil)
 	}
 
 	if c.config.UnixSocketConfig != nil {
@@ -615,14 +1707,34 @@ func (c *Client) Start() (addr net.Addr, err error) {
 		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", EnvUnixSocketGroup, c.unixSocketCfg.Group))
 	}
 
+	if c.unixSocketCfg.FixedName != "" {
+		if c.config.ForceTCP {
+			return nil, errors.New("UnixSocketConfig.FixedName is not supported with ForceTCP")
+		}
+		if c.config.RunnerFunc != nil {
+			return nil, errors.New("UnixSocketConfig.FixedName is not supported with RunnerFunc")
+		}
+		path, err := fixedSocketPath(c.unixSocketCfg)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", EnvUnixSocketPath, path))
+	}
+
 	var runner runner.Runner
 	switch {
+	case c.config.RunnerFunc != nil && c.config.ForceTCP:
+		// The plugin listens on TCP, so it needs no directory for sockets.
+		runner, err = c.config.RunnerFunc(c.logger, cmd, "")
+		if err != nil {
+			return nil, err
+		}
 	case c.config.RunnerFunc != nil:
-		c.unixSocketCfg.socketDir, err = os.MkdirTemp(c.unixSocketCfg.TempDir, "plugin-dir")
+		c.unixSocketCfg.socketDir, err = createSocketDir(c.unixSocketCfg.TempDir)
 		if err != nil {
 			return nil, err
 		}
-		// os.MkdirTemp creates folders with 0o700, so if we have a group
+		// os.MkdirTemp, used by createSocketDir, creates folders with 0o700, so if we have a group
 		// configured we need to make it group-writable.
 		if c.unixSocketCfg.Group != "" {
 			err = setGroupWritable(c.unixSocketCfg.socketDir, c.unixSocketCfg.Group, 0o770)
@@ -645,13 +1757,39 @@ func (c *Client) Start() (addr net.Addr, err error) {
 
 	}
 
+	c.snapshotCommand(cmd, runner)
 	c.runner = runner
 	startCtx, startCtxCancel := context.WithTimeout(context.Background(), c.config.StartTimeout)
 	defer startCtxCancel()
+	if verified != nil {
+		// The plugin is traced by the thread that starts it, and only that
+		// thread can release it.
+		runtime.LockOSThread()
+	}
 	err = runner.Start(startCtx)
 	if err != nil {
+		if verified != nil {
+			runtime.UnlockOSThread()
+		}
 		return nil, err
 	}
+	if verified != nil {
+		if err = releaseExec(startCtx, cmd, verified); err != nil {
+			runner.Kill(context.Background())
+			return nil, err
+		}
+	}
+	c.startTime = time.Now()
+	if c.singleton != nil {
+		c.singleton.setPid(runner.ID())
+	}
+
+	// Tag everything logged about the plugin from now on with its pid.
+	c.logger = c.config.Logger.With("pid", runner.ID())
+	l = c.logger
+
+	c.events = c.newEventLogger(runner)
+	c.event(slog.LevelInfo, "plugin started", "path", runner.Name())
 
 	// Make sure the command is properly cleaned up if there is an error
 	defer func() {
@@ -669,12 +1807,27 @@ func (c *Client) Start() (addr net.Addr, err error) {
 	// Create a context for when we kill
 	c.doneCtx, c.ctxCancel = context.WithCancel(context.Background())
 
-	// Start goroutine that logs the stderr
-	c.clientWaitGroup.Add(1)
-	c.stderrWaitGroup.Add(1)
-	// logStderr calls Done()
-	go c.logStderr(runner.Name(), runner.Stderr())
+	// A runner without stdout must report the handshake another way.
+	stdout := runner.Stdout()
+	reporter, isReporter := readinessReporter(runner)
+	if stdout == nil && !isReporter {
+		return nil, fmt.Errorf("runner for %s provides no stdout and does not implement runner.ReadinessReporter", runner.Name())
+	}
+
+	// Start goroutine that logs the stderr, if the runner provides it.
+	c.stderrLogger.Store(c.withEvents(log.NewLogger(&log.HandlerOptions{Name: filepath.Base(runner.Name()), AddSource: false}).With("pid", runner.ID())))
+	if stderr := runner.Stderr(); stderr != nil {
+		c.clientWaitGroup.Add(1)
+		c.stderrWaitGroup.Add(1)
+		// logStderr calls Done()
+		go c.logStderr(stderr)
+	}
+	defer c.startDone.Store(true)
 
+	doneCtx := c.doneCtx
+	startTime := c.startTime
+	singleton := c.singleton
+	extractedDir := c.extractedDir
 	c.clientWaitGroup.Add(1)
 	go func() {
 		// ensure the context is cancelled when we're done
@@ -689,29 +1842,77 @@ func (c *Client) Start() (addr net.Addr, err error) {
 		// Wait for the command to end.
 		err := runner.Wait(context.Background())
 		if err != nil {
-			c.logger.Error("plugin process exited", "plugin", runner.Name(), "id", runner.ID(), "error", err.Error())
+			c.logger.Error("plugin process exited", "plugin", runner.Name(), "error", err.Error())
+			c.event(slog.LevelError, "plugin exited", "error", err.Error())
 		} else {
 			// Log and make sure to flush the logs right away
-			c.logger.Debug("plugin process exited", "plugin", runner.Name(), "id", runner.ID())
+			c.logger.Debug("plugin process exited", "plugin", runner.Name())
+			c.event(slog.LevelInfo, "plugin exited")
+		}
+		c.lifecycle(LifecycleExited, runner.Name(), time.Since(startTime), err)
+		singleton.release()
+		if extractedDir != "" {
+			os.RemoveAll(extractedDir)
 		}
 
 		os.Stderr.Sync()
 
 		// Set that we exited, which takes a lock
 		c.m.Lock()
-		defer c.m.Unlock()
 		c.exited = true
+		c.m.Unlock()
+
+		if c.config.OnExit != nil {
+			c.config.OnExit(err)
+		}
+
+		c.m.Lock()
+		crashed := !c.killing && c.config.AutoRestart != nil
+		var crashLoopErrs []error
+		var crashLooping bool
+		if crashed {
+			crashLoopErrs, crashLooping = c.recordCrash(err)
+		}
+		restarting := c.restarting
+		c.m.Unlock()
+		switch {
+		case crashLooping:
+			c.logger.Error("plugin is crash looping, not restarting it", "crashes", len(crashLoopErrs))
+			c.event(slog.LevelError, "plugin crash looping", "crashes", len(crashLoopErrs))
+			if c.config.OnCrashLoop != nil {
+				c.config.OnCrashLoop(crashLoopErrs)
+			}
+		case crashed && !restarting:
+			go c.autoRestart(doneCtx)
+		}
 	}()
 
 	// Start a goroutine that is going to be reading the lines
 	// out of stdout
 	linesCh := make(chan string)
+	var partial *partialLineReader
+	if stdout != nil {
+		partial = &partialLineReader{r: stdout}
+	}
 	c.clientWaitGroup.Add(1)
 	go func() {
 		defer c.clientWaitGroup.Done()
 		defer close(linesCh)
 
-		scanner := bufio.NewScanner(runner.Stdout())
+		if stdout == nil {
+			line, err := reporter.Ready(c.doneCtx)
+			if err != nil {
+				c.logger.Error("error waiting for plugin to be ready", "error", err)
+				return
+			}
+			linesCh <- line
+			return
+		}
+
+		scanner := bufio.NewScanner(partial)
+		if c.config.StdoutReaderSize > 0 {
+			scanner.Buffer(make([]byte, 0, c.config.StdoutReaderSize), c.config.StdoutReaderSize)
+		}
 		for scanner.Scan() {
 			linesCh <- scanner.Text()
 		}
@@ -736,105 +1937,465 @@ func (c *Client) Start() (addr net.Addr, err error) {
 	// Some channels for the next step
 	timeout := time.After(c.config.StartTimeout)
 
-	// Start looking for the address
+	filter := &handshakeFilter{prefix: c.config.HandshakePrefix}
+
+	// Check for a partial handshake line until the first handshake is read.
+	var partialCheck <-chan time.Time
+	if partial != nil && c.config.PartialHandshakeTimeout > 0 {
+		ticker := time.NewTicker(c.config.PartialHandshakeTimeout / 4)
+		defer ticker.Stop()
+		partialCheck = ticker.C
+	}
+
+	// Start looking for the address. If HandshakeSettleTime is set, keep
+	// reading stdout for a while after each handshake, so that a later
+	// handshake supersedes an earlier one.
 	c.logger.Debug("waiting for RPC address", "plugin", runner.Name())
-	select {
-	case <-timeout:
-		err = errors.New("timeout while waiting for plugin to start")
-	case <-c.doneCtx.Done():
-		err = errors.New("plugin exited before we could connect")
-	case line := <-linesCh:
-		// Trim the line and split by "|" in order to get the parts of
-		// the output.
-		line = strings.TrimSpace(line)
-		//fmt.Println("line", line)
-		parts := strings.SplitN(line, "|", 6)
-		//fmt.Println("line", parts)
-		/*
-			if len(parts) < 4 {
-				errText := fmt.Sprintf("Unrecognized remote plugin message: %s", line)
+	var settle <-chan time.Time
+handshake:
+	for {
+		select {
+		case <-settle:
+			break handshake
+		case <-partialCheck:
+			if addr != nil {
+				partialCheck = nil
+				continue
+			}
+			if err = partial.stale(c.config.PartialHandshakeTimeout); err != nil {
+				return nil, withDiagnosis(runner, err)
+			}
+		case <-timeout:
+			if addr != nil && !c.config.MultiplexHandshakes {
+				break handshake
+			}
+			err = errors.New("timeout while waiting for plugin to start")
+			if partial != nil {
+				if perr := partial.stale(0); perr != nil {
+					err = fmt.Errorf("timeout while waiting for plugin to start: %w", perr)
+				}
+			}
+			if addr != nil {
+				err = fmt.Errorf("timeout while waiting for plugin handshakes, missing: %s",
+					strings.Join(c.missingHandshakes(), ", "))
+			}
+			if diag := c.startupDiag.Load(); diag != nil {
+				err = fmt.Errorf("timeout while waiting for plugin to start: %w", diag)
+			}
+			return nil, withDiagnosis(runner, err)
+		case <-c.startupFailed:
+			return nil, fmt.Errorf("plugin failed to start: %w", c.startupDiag.Load())
+		case <-c.doneCtx.Done():
+			err = errors.New("plugin exited before we could connect")
+			if diag := c.startupDiag.Load(); diag != nil {
+				err = fmt.Errorf("plugin exited before we could connect: %w", diag)
+			}
+			return nil, withDiagnosis(runner, err)
+		case line, ok := <-linesCh:
+			if ok {
+				handshakeLine, isHandshake := filter.filter(line)
+				if !isHandshake {
+					c.logger.Debug("plugin stdout before handshake", "line", line)
+					fmt.Fprintln(c.config.SyncStdout, line)
+					continue
+				}
+				line = handshakeLine
+			}
+			if c.config.MultiplexHandshakes {
 				if !ok {
-					errText += "\n" + "Failed to read any lines from plugin's stdout"
+					return nil, fmt.Errorf("plugin closed stdout before all handshakes were read, missing: %s",
+						strings.Join(c.missingHandshakes(), ", "))
 				}
-				additionalNotes := runner.Diagnose(context.Background())
-				if additionalNotes != "" {
-					errText += "\n" + additionalNotes
+				pluginAddr, done, err := c.addMultiplexedHandshake(runner, line)
+				if err != nil {
+					return nil, err
 				}
-				err = errors.New(errText)
-				return
+				if addr == nil {
+					addr = pluginAddr
+				}
+				if done {
+					break handshake
+				}
+				continue
 			}
 
-			// Check the core protocol. Wrapped in a {} for scoping.
-			{
-				var coreProtocol int
-				coreProtocol, err = strconv.Atoi(parts[0])
+			if addr != nil {
+				if !ok {
+					break handshake
+				}
+				if strings.Count(line, "|") < 3 {
+					c.logger.Debug("ignoring plugin stdout after handshake", "line", line)
+					continue
+				}
+				newAddr, parseErr := c.parseHandshake(runner, line)
+				if parseErr != nil {
+					c.logger.Debug("ignoring plugin stdout after handshake", "line", line, "error", parseErr)
+					continue
+				}
+				c.logger.Debug("plugin handshake superseded", "address", addr, "newAddress", newAddr)
+				addr = newAddr
+			} else {
+				if !ok {
+					// A plugin that closed stdout without a handshake
+					// may still be reporting why on stderr.
+					if diag := c.waitStartupDiag(time.Second); diag != nil {
+						return nil, fmt.Errorf("plugin failed to start: %w", diag)
+					}
+					return nil, unrecognizedHandshake(runner, line, false)
+				}
+				addr, err = c.parseHandshake(runner, line)
 				if err != nil {
-					err = fmt.Errorf("Error parsing core protocol version: %s", err)
-					return
+					return nil, err
 				}
+			}
 
-				if coreProtocol != CoreProtocolVersion {
-					err = fmt.Errorf("Incompatible core API version with plugin. "+
-						"Plugin version: %s, Core version: %d\n\n"+
-						"To fix this, the plugin usually only needs to be recompiled.\n"+
-						"Please report this to the plugin author.", parts[0], CoreProtocolVersion)
-					return
-				}
+			if c.config.HandshakeSettleTime <= 0 {
+				break handshake
 			}
-		*/
+			settle = time.After(c.config.HandshakeSettleTime)
+		}
+	}
+
+	if c.config.PostHandshakeWait != nil {
+		if err = c.postHandshakeWait(startCtx, addr); err != nil {
+			return nil, err
+		}
+	}
+
+	c.address = addr
+	c.startIdleTimer()
+	c.startFDWatch(c.doneCtx)
+
+	if c.config.OnAddressResolved != nil {
+		c.config.OnAddressResolved(addr)
+	}
+
+	c.event(slog.LevelInfo, "plugin handshake completed",
+		"address", addr.String(),
+		"protocolVersion", c.negotiatedVersion)
+	c.lifecycle(LifecycleStarted, runner.Name(), time.Since(c.startTime), nil)
+
+	// Attribute every subsequent plugin log line to the protocol in use.
+	c.stderrLogger.Store(c.stderrLogger.Load().With(
+		"protocolVersion", c.negotiatedVersion,
+		"transport", addr.Network()))
+	return
+}
+
+// postHandshakeWait calls ClientConfig.PostHandshakeWait for every address
+// the plugin advertised. It gives up once ctx is done or the plugin exited.
+func (c *Client) postHandshakeWait(ctx context.Context, addr net.Addr) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(c.doneCtx, cancel)
+	defer stop()
+
+	addrs := []net.Addr{addr}
+	if c.pluginAddrs != nil {
+		addrs = addrs[:0]
+		for _, a := range c.pluginAddrs {
+			addrs = append(addrs, a)
+		}
+	}
+	for _, a := range addrs {
+		if err := c.config.PostHandshakeWait(ctx, a); err != nil {
+			return fmt.Errorf("error waiting for plugin address %s: %w", a, err)
+		}
+	}
+	return nil
+}
+
+// startIdleTimer starts tracking how long the plugin is idle, if an
+// IdleTimeout is configured. The caller must hold c.m.
+func (c *Client) startIdleTimer() {
+	if c.config.IdleTimeout <= 0 {
+		return
+	}
+	c.idle = newIdleTracker(c.config.IdleTimeout, func() {
+		c.logger.Debug("plugin idle, killing it", "idleTimeout", c.config.IdleTimeout)
+		c.Kill()
+	})
+}
 
-		// Test the API version
-		version, plugins, err := c.checkProtoVersion("1")
+// readinessReporter returns r as a runner.ReadinessReporter, if it is one.
+func readinessReporter(r runner.Runner) (runner.ReadinessReporter, bool) {
+	rr, ok := r.(runner.ReadinessReporter)
+	return rr, ok
+}
+
+// unrecognizedHandshake returns the error for a line that is not a valid
+// handshake. readAny is false if the plugin closed stdout without printing
+// anything.
+// waitStartupDiag waits up to timeout for the plugin's stderr to be read to
+// the end, and returns the startup diagnostic it reported, if any.
+func (c *Client) waitStartupDiag(timeout time.Duration) *StartupDiagnostic {
+	drained := make(chan struct{})
+	go func() {
+		c.stderrWaitGroup.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-c.startupFailed:
+	case <-time.After(timeout):
+	}
+	return c.startupDiag.Load()
+}
+
+func unrecognizedHandshake(runner runner.Runner, line string, readAny bool) error {
+	errText := fmt.Sprintf("Unrecognized remote plugin message: %s", line)
+	if !readAny {
+		errText += "\n" + "Failed to read any lines from plugin's stdout"
+	}
+	return withDiagnosis(runner, errors.New(errText))
+}
+
+// withDiagnosis appends the runner's diagnosis of a failed start to err.
+func withDiagnosis(runner runner.Runner, err error) error {
+	if notes := runner.Diagnose(context.Background()); notes != "" {
+		return fmt.Errorf("%w\n%s", err, notes)
+	}
+	return err
+}
+
+// reattach attaches to the running plugin described by
+// ClientConfig.Reattach, instead of launching a new one. The caller must
+// hold c.m.
+func (c *Client) reattach() (net.Addr, error) {
+	reattachFunc := c.config.Reattach.ReattachFunc
+	// Default to attaching to a plain process.
+	if reattachFunc == nil {
+		reattachFunc = cmdrunner.ReattachFunc(c.config.Reattach.Pid, c.config.Reattach.Addr)
+	}
+
+	version, plugins, err := c.checkProtoVersion(strconv.Itoa(c.config.Reattach.ProtocolVersion))
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := reattachFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a context for when we kill
+	c.doneCtx, c.ctxCancel = context.WithCancel(context.Background())
+
+	c.clientWaitGroup.Add(1)
+	// Goroutine to mark exit status
+	go func(r runner.AttachedRunner) {
+		defer c.clientWaitGroup.Done()
+
+		// ensure the context is cancelled when we're done
+		defer c.ctxCancel()
+
+		// Wait for the process to die
+		err := r.Wait(context.Background())
+
+		// Log so we can see it
+		c.logger.Debug("reattached plugin process exited")
+
+		// Mark it
+		c.m.Lock()
+		c.exited = true
+		c.m.Unlock()
+
+		if c.config.OnExit != nil {
+			c.config.OnExit(err)
+		}
+	}(r)
+
+	// Set the address and protocol
+	c.address = c.config.Reattach.Addr
+	c.startIdleTimer()
+	c.startFDWatch(c.doneCtx)
+	c.negotiatedVersion = version
+	c.negotiatedPlugins = plugins
+
+	// If we're in test mode, we do NOT set the runner. This will prevent
+	// us from killing the process.
+	if !c.config.Reattach.Test {
+		c.runner = r
+	}
+
+	return c.address, nil
+}
+
+// splitHandshake splits a handshake line into its fields: the core protocol
+// version, the app protocol version, the network, the address, the protocol,
+// the AutoMTLS server certificate, the library version and the plugin name,
+// of which all but the first four are optional.
+//
+// Plugins built with versions of this library before the library version was
+// added to the handshake print five fields, with the certificate in place of
+// the protocol. Their handshake is returned with the "grpc" protocol they
+// speak inserted, so the fields are at the same position in both layouts.
+func splitHandshake(line string) []string {
+	parts := strings.SplitN(strings.TrimSpace(line), "|", 8)
+	if len(parts) == 5 && parts[4] != "grpc" {
+		parts = []string{parts[0], parts[1], parts[2], parts[3], "grpc", parts[4]}
+	}
+	return parts
+}
+
+// parseHandshake parses a handshake line printed by the plugin, negotiates
+// the protocol version and returns the address to connect to.
+func (c *Client) parseHandshake(runner runner.Runner, line string) (net.Addr, error) {
+	line = strings.TrimSpace(line)
+	parts := splitHandshake(line)
+	if len(parts) < 4 {
+		return nil, unrecognizedHandshake(runner, line, true)
+	}
+
+	// Check the core protocol. Wrapped in a {} for scoping.
+	{
+		coreProtocol, err := strconv.Atoi(parts[0])
 		if err != nil {
-			return addr, err
+			return nil, fmt.Errorf("Error parsing core protocol version: %s", err)
+		}
+
+		if coreProtocol != CoreProtocolVersion {
+			return nil, fmt.Errorf("Incompatible core API version with plugin. "+
+				"Plugin version: %s, Core version: %d\n\n"+
+				"To fix this, the plugin usually only needs to be recompiled.\n"+
+				"Please report this to the plugin author.", parts[0], CoreProtocolVersion)
 		}
+	}
+
+	// Test the API version
+	version, plugins, err := c.checkProtoVersion(parts[1])
+	if err != nil {
+		return nil, err
+	}
 
-		// set the Plugins value to the compatible set, so the version
-		// doesn't need to be passed through to the ClientProtocol
-		// implementation.
-		c.negotiatedPlugins = plugins
-		c.negotiatedVersion = version
-		c.logger.Debug("using plugin", "version", version)
+	// set the Plugins value to the compatible set, so the version
+	// doesn't need to be passed through to the ClientProtocol
+	// implementation.
+	c.negotiatedPlugins = plugins
+	c.negotiatedVersion = version
+	c.logger.Debug("using plugin", "version", version)
 
-		network, address, err := runner.PluginToHost(parts[2], parts[3])
+	network, address, err := runner.PluginToHost(parts[2], parts[3])
+	if err != nil {
+		return nil, err
+	}
+
+	var addr net.Addr
+	switch network {
+	case "tcp":
+		addr, err = net.ResolveTCPAddr("tcp", address)
 		if err != nil {
-			return addr, err
+			return nil, fmt.Errorf("tcp address error: %s", err)
+		}
+	case "unix":
+		// A chrooted plugin reports the path of its socket inside the chroot.
+		if c.config.Sandbox != nil && c.config.Sandbox.Chroot != "" {
+			address = filepath.Join(c.config.Sandbox.Chroot, address)
 		}
+		addr, err = net.ResolveUnixAddr("unix", address)
+		if err != nil {
+			return nil, fmt.Errorf("unix address error: %s", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown address type: %s", address)
+	}
 
-		switch network {
-		case "tcp":
-			addr, err = net.ResolveTCPAddr("tcp", address)
-			if err != nil {
-				return nil, fmt.Errorf("tcp address error: %s", err)
-			}
-		case "unix":
-			addr, err = net.ResolveUnixAddr("unix", address)
-			if err != nil {
-				return nil, fmt.Errorf("unix address error: %s", err)
-			}
-		default:
-			return nil, fmt.Errorf("unknown address type: %s", address)
+	// See if we have a TLS certificate from the server.
+	// Checking if the length is > 50 rules out catching the unused "extra"
+	// data returned from some older implementations.
+	if len(parts) >= 6 && len(parts[5]) > 50 {
+		err := c.loadServerCert(parts[5])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing server cert: %s", err)
 		}
+	}
 
-		// See if we have a TLS certificate from the server.
-		// Checking if the length is > 50 rules out catching the unused "extra"
-		// data returned from some older implementations.
-		if len(parts) >= 6 && len(parts[5]) > 50 {
-			err := c.loadServerCert(parts[5])
-			if err != nil {
-				return nil, fmt.Errorf("error parsing server cert: %s", err)
+	// Plugins built with this library advertise its version last.
+	if len(parts) >= 7 {
+		c.pluginLibraryVersion = parts[6]
+		if !libraryVersionsCompatible(c.pluginLibraryVersion, LibraryVersion) {
+			c.logger.Warn("plugin was built with a different version of the plugin library",
+				"plugin", runner.Name(),
+				"pluginVersion", c.pluginLibraryVersion,
+				"hostVersion", LibraryVersion)
+		}
+	}
+
+	return addr, nil
+}
+
+// validatePortRange checks that the MinPort-MaxPort range of a ClientConfig
+// is a valid range of TCP ports, to fail before the plugin fails to bind.
+func validatePortRange(minPort, maxPort uint) error {
+	if minPort < 1 || minPort > 65535 {
+		return fmt.Errorf("invalid MinPort %d: must be between 1 and 65535", minPort)
+	}
+	if maxPort < 1 || maxPort > 65535 {
+		return fmt.Errorf("invalid MaxPort %d: must be between 1 and 65535", maxPort)
+	}
+	if minPort > maxPort {
+		return fmt.Errorf("invalid port range: MinPort %d is greater than MaxPort %d", minPort, maxPort)
+	}
+	return nil
+}
+
+// resolveBindAddress resolves ClientConfig.BindInterface to the IP address the
+// plugin should bind to, checking that it belongs to one of the host's
+// interfaces.
+func resolveBindAddress(bindInterface string) (string, error) {
+	if ip := net.ParseIP(bindInterface); ip != nil {
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return "", fmt.Errorf("cannot list interface addresses: %w", err)
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return ip.String(), nil
 			}
 		}
+		return "", fmt.Errorf("bind address %s is not assigned to any interface", bindInterface)
 	}
 
-	c.address = addr
-	return
+	iface, err := net.InterfaceByName(bindInterface)
+	if err != nil {
+		return "", fmt.Errorf("cannot find bind interface %q: %w", bindInterface, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("cannot list addresses of interface %q: %w", bindInterface, err)
+	}
+
+	var found net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP.String(), nil
+		}
+		if found == nil {
+			found = ipNet.IP
+		}
+	}
+	if found == nil {
+		return "", fmt.Errorf("interface %q has no IP address", bindInterface)
+	}
+	return found.String(), nil
 }
 
 // loadServerCert is used by AutoMTLS to read an x.509 cert returned by the
 // server, and load it as the RootCA and ClientCA for the client TLSConfig.
+// With MultiplexHandshakes, every plugin presents its own certificate, so
+// they are all added to the same pool.
 func (c *Client) loadServerCert(cert string) error {
 	certPool := x509.NewCertPool()
+	switch {
+	case c.config.MultiplexHandshakes && c.config.TLSConfig.RootCAs != nil:
+		certPool = c.config.TLSConfig.RootCAs.Clone()
+	case c.config.AutoMTLSRootCAs != nil:
+		certPool = c.config.AutoMTLSRootCAs.Clone()
+	}
 
 	asn1, err := base64.RawStdEncoding.DecodeString(cert)
 	if err != nil {
@@ -847,6 +2408,11 @@ func (c *Client) loadServerCert(cert string) error {
 	}
 
 	certPool.AddCert(x509Cert)
+	// Of the certificates of multiplexed plugins, the one expiring first
+	// is checked by checkServerCert.
+	if prev := c.serverCert.Load(); !c.config.MultiplexHandshakes || prev == nil || x509Cert.NotAfter.Before(prev.NotAfter) {
+		c.serverCert.Store(x509Cert)
+	}
 
 	c.config.TLSConfig.RootCAs = certPool
 	c.config.TLSConfig.ClientCAs = certPool
@@ -861,27 +2427,75 @@ func (c *Client) checkProtoVersion(protoVersion string) (int, PluginSet, error)
 	}
 
 	// record these for the error message
-	var clientVersions []int
+	supported := make(map[int][]string, len(c.config.VersionedPlugins))
 
 	// all versions, including the legacy ProtocolVersion have been added to
 	// the versions set
 	for version, plugins := range c.config.VersionedPlugins {
-		clientVersions = append(clientVersions, version)
+		if serverVersion == version {
+			if c.config.OnVersionNegotiated != nil {
+				c.config.OnVersionNegotiated(version, pluginNames(plugins))
+			}
+			return version, plugins, nil
+		}
 
-		if serverVersion != version {
-			continue
+		names := make([]string, 0, len(plugins))
+		for name := range plugins {
+			names = append(names, name)
 		}
-		return version, plugins, nil
+		sort.Strings(names)
+		supported[version] = names
 	}
 
-	return 0, nil, fmt.Errorf("incompatible API version with plugin. "+
-		"Plugin version: %d, Client versions: %d", serverVersion, clientVersions)
+	return 0, nil, &VersionMismatchError{
+		PluginVersion: serverVersion,
+		Supported:     supported,
+	}
+}
+
+// VersionMismatchError is returned by Start when the plugin speaks a
+// protocol version the client has no VersionedPlugins for.
+type VersionMismatchError struct {
+	// PluginVersion is the protocol version the plugin advertised in its
+	// handshake, or the one given for it in a ReattachConfig or
+	// DiscoveryConfig.
+	PluginVersion int
+
+	// Supported holds the sorted names of the plugins the client expects
+	// at each protocol version it supports.
+	Supported map[int][]string
+}
+
+func (e *VersionMismatchError) Error() string {
+	versions := make([]int, 0, len(e.Supported))
+	for v := range e.Supported {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "incompatible API version with plugin. "+
+		"Plugin version: %d, Client versions: %d", e.PluginVersion, versions)
+	for _, v := range versions {
+		fmt.Fprintf(&b, "\n  version %d requires a plugin providing: %s", v, strings.Join(e.Supported[v], ", "))
+	}
+	return b.String()
 }
 
 // dialer is compatible with grpc.WithDialer and creates the connection
 // to the plugin.
 func (c *Client) dialer(_ string, timeout time.Duration) (net.Conn, error) {
-	conn, err := netAddrDialer(c.address)("", timeout)
+	// gRPC reconnects on its own after the connection dropped.
+	if err := c.checkServerCert(); err != nil {
+		return nil, err
+	}
+
+	addr, err := c.resolveAddr(timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := netAddrDialer(addr)("", timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -889,19 +2503,34 @@ func (c *Client) dialer(_ string, timeout time.Duration) (net.Conn, error) {
 	return conn, nil
 }
 
+// addrDialer is like dialer, but connects to addr, the address one of the
+// plugins of a multiplexed process is served on.
+func (c *Client) addrDialer(addr net.Addr) func(string, time.Duration) (net.Conn, error) {
+	return func(_ string, timeout time.Duration) (net.Conn, error) {
+		if err := c.checkServerCert(); err != nil {
+			return nil, err
+		}
+		return netAddrDialer(addr)("", timeout)
+	}
+}
+
 var stdErrBufferSize = 64 * 1024
 
-func (c *Client) logStderr(name string, r io.Reader) {
+func (c *Client) logStderr(r io.Reader) {
 	defer c.clientWaitGroup.Done()
 	defer c.stderrWaitGroup.Done()
-	l := log.NewLogger(&log.HandlerOptions{Name: filepath.Base(name), AddSource: false})
 
-	reader := bufio.NewReaderSize(r, stdErrBufferSize)
+	size := stdErrBufferSize
+	if c.config.MaxStderrLineSize > 0 {
+		size = c.config.MaxStderrLineSize
+	}
+	reader := bufio.NewReaderSize(r, size)
 	// continuation indicates the previous line was a prefix
 	continuation := false
 
 	for {
 		line, isPrefix, err := reader.ReadLine()
+		l := c.stderrLogger.Load()
 		switch {
 		case err == io.EOF:
 			return
@@ -912,6 +2541,10 @@ func (c *Client) logStderr(name string, r io.Reader) {
 
 		c.config.Stderr.Write(line)
 
+		if c.config.OnStartupOutput != nil && !c.startDone.Load() {
+			c.config.OnStartupOutput(string(line))
+		}
+
 		// The line was longer than our max token size, so it's likely
 		// incomplete and won't unmarshal.
 		if isPrefix || continuation {
@@ -928,49 +2561,50 @@ func (c *Client) logStderr(name string, r io.Reader) {
 
 		c.config.Stderr.Write([]byte{'\n'})
 
+		if diag, ok := parseDiagnostic(line); ok {
+			c.startupDiag.Store(diag)
+			select {
+			case c.startupFailed <- struct{}{}:
+			default:
+			}
+			l.Error("plugin reported startup failure", "stage", diag.Stage, "error", diag.Message)
+			continue
+		}
+
 		entry, err := parseJSON(line)
 		// If output is not JSON format, print directly to Debug
 		if err != nil {
 			// Attempt to infer the desired log level from the commonly used
 			// string prefixes
-			switch line := string(line); {
-			case strings.HasPrefix(line, "[TRACE]"):
-				l.Debug(line)
-			case strings.HasPrefix(line, "[DEBUG]"):
-				l.Debug(line)
-			case strings.HasPrefix(line, "[INFO]"):
-				l.Info(line)
-			case strings.HasPrefix(line, "[WARN]"):
-				l.Warn(line)
-			case strings.HasPrefix(line, "[ERROR]"):
-				l.Error(line)
-			default:
-				l.Debug(line)
+			prefixes := c.config.StderrLevelPrefixes
+			if prefixes == nil {
+				prefixes = defaultStderrLevelPrefixes
 			}
+			line := string(line)
+			l.Log(context.Background(), prefixLevel(prefixes, line), line)
 		} else {
+			if c.config.StderrJSONHandler != nil {
+				c.config.StderrJSONHandler(entry)
+				continue
+			}
+
 			out := flattenKVPairs(entry.KVPairs)
 
-			l.Debug(entry.Message, out...)
-			/*
-				out = append(out, "timestamp", entry.Timestamp.Format(log.TimeFormat))
-				switch slog.LevelFromString(entry.Level) {
-				case slog.Debug:
-					l.Trace(entry.Message, out...)
-				case hclog.Debug:
-					l.Debug(entry.Message, out...)
-				case hclog.Info:
-					l.Info(entry.Message, out...)
-				case hclog.Warn:
-					l.Warn(entry.Message, out...)
-				case hclog.Error:
-					l.Error(entry.Message, out...)
-				default:
-					// if there was no log level, it's likely this is unexpected
-					// json from something other than hclog, and we should output
-					// it verbatim.
-					l.Debug(string(line))
-				}
-			*/
+			level, ok := logEntryLevel(entry.Level)
+			if !ok {
+				// if there was no log level, it's likely this is unexpected
+				// json from something other than hclog, and we should output
+				// it verbatim.
+				l.Debug(string(line))
+				continue
+			}
+			// Keep the plugin's own timestamp and level, e.g. to correlate
+			// with host logs or to filter on trace entries.
+			if !entry.Timestamp.IsZero() {
+				out = append(out, slog.Time("plugin_ts", entry.Timestamp))
+			}
+			out = append(out, slog.String("plugin_level", entry.Level))
+			l.Log(context.Background(), level, entry.Message, out...)
 		}
 	}
 }
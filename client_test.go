@@ -0,0 +1,458 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kform-dev/plugin/cmdrunner"
+	"github.com/kform-dev/plugin/runner"
+)
+
+// hangingClient is a ClientProtocol whose connection never closes, nor
+// answers pings.
+type hangingClient struct {
+	ClientProtocol
+	release chan struct{}
+}
+
+func (c *hangingClient) Close() error {
+	<-c.release
+	return nil
+}
+
+func (c *hangingClient) Ping() error {
+	<-c.release
+	return nil
+}
+
+// killRecordingRunner records that the plugin was killed.
+type killRecordingRunner struct {
+	runner.Runner
+	killed chan struct{}
+}
+
+func (r *killRecordingRunner) ID() string { return "1" }
+
+func (r *killRecordingRunner) Kill(context.Context) error {
+	close(r.killed)
+	return nil
+}
+
+func TestClientKill_hungClose(t *testing.T) {
+	defer func(timeout time.Duration) { killCloseTimeout = timeout }(killCloseTimeout)
+	killCloseTimeout = 50 * time.Millisecond
+
+	hung := &hangingClient{release: make(chan struct{})}
+	defer close(hung.release)
+	r := &killRecordingRunner{killed: make(chan struct{})}
+
+	c := NewClient(&ClientConfig{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	c.runner = r
+	c.address = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	c.client = hung
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Kill()
+	}()
+
+	select {
+	case <-r.killed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("plugin was not force killed while the client close hung")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Kill did not return")
+	}
+}
+
+func TestClientStart_discoveryAndLocal(t *testing.T) {
+	resolve := func(context.Context, string) (net.Addr, error) {
+		return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}, nil
+	}
+
+	cases := []struct {
+		name   string
+		config func(*ClientConfig)
+	}{
+		{"secure config", func(c *ClientConfig) { c.SecureConfig = &SecureConfig{} }},
+		{"sandbox", func(c *ClientConfig) { c.Sandbox = &SandboxConfig{} }},
+		{"compressed binary", func(c *ClientConfig) { c.CompressedBinary = &CompressedBinary{} }},
+		{"singleton", func(c *ClientConfig) { c.Singleton = true }},
+		{"auto mtls", func(c *ClientConfig) { c.AutoMTLS = true }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &ClientConfig{
+				HandshakeConfig:  testHandshake,
+				VersionedPlugins: map[int]PluginSet{1: testPluginSet},
+				Discovery:        &DiscoveryConfig{ServiceName: "test", Resolve: resolve, ProtocolVersion: 1},
+				Logger:           testLogger(),
+			}
+			tc.config(config)
+
+			c := NewClient(config)
+			defer c.Kill()
+			if _, err := c.Start(); !errors.Is(err, ErrDiscoveryAndLocal) {
+				t.Fatalf("expected ErrDiscoveryAndLocal, got %v", err)
+			}
+		})
+	}
+}
+
+func TestClientKill_discovery(t *testing.T) {
+	plugin := testStartClient(t, testClientConfig("test-grpc"))
+	addr := plugin.ReattachConfig().Addr
+
+	c := NewClient(&ClientConfig{
+		HandshakeConfig:  testHandshake,
+		VersionedPlugins: map[int]PluginSet{1: testPluginSet},
+		Discovery: &DiscoveryConfig{
+			ServiceName: "test",
+			Resolve: func(context.Context, string) (net.Addr, error) {
+				return addr, nil
+			},
+			ProtocolVersion: 1,
+		},
+		Logger:       testLogger(),
+		StartTimeout: 10 * time.Second,
+	})
+	if _, err := c.Client(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	c.Kill()
+	select {
+	case <-c.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Kill did not cancel the context of the discovered plugin")
+	}
+	if plugin.Exited() {
+		t.Fatal("Kill stopped the discovered plugin")
+	}
+}
+
+func TestClientClient_same(t *testing.T) {
+	cases := []struct {
+		name   string
+		config func(*ClientConfig)
+	}{
+		{"plain", func(*ClientConfig) {}},
+		{"auto restart", func(c *ClientConfig) { c.AutoRestart = &RestartPolicy{} }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := testClientConfig("test-grpc")
+			tc.config(config)
+			c := testStartClient(t, config)
+
+			first, err := c.Client()
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			second, err := c.Client()
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if first != second {
+				t.Fatal("Client returned another client")
+			}
+		})
+	}
+}
+
+// wrappingRunner is a runner reporting that the plugin is run through a
+// wrapper.
+type wrappingRunner struct {
+	runner.Runner
+	cmd *exec.Cmd
+}
+
+func (r *wrappingRunner) Command() ([]string, []string) {
+	return append([]string{"wrapper"}, r.cmd.Args...), r.cmd.Env
+}
+
+func TestClientCommand(t *testing.T) {
+	env := []string{"TEST_SECRET=secret", "TEST_PUBLIC=public"}
+	cases := []struct {
+		name   string
+		config func(*ClientConfig)
+		argv0  string
+	}{
+		{"cmd", func(*ClientConfig) {}, os.Args[0]},
+		{"runner func", func(c *ClientConfig) {
+			c.Cmd = nil
+			c.RunnerFunc = func(l *slog.Logger, cmd *exec.Cmd, _ string) (runner.Runner, error) {
+				plugin := helperProcess("test-grpc", env...)
+				plugin.Env = append(plugin.Env, cmd.Env...)
+				r, err := cmdrunner.NewCmdRunner(l, plugin)
+				return &wrappingRunner{Runner: r, cmd: plugin}, err
+			}
+		}, "wrapper"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := testClientConfig("test-grpc", env...)
+			config.RedactEnv = []string{"TEST_SECRET"}
+			tc.config(config)
+			c := testStartClient(t, config)
+
+			if line := c.CommandLine(); len(line) == 0 || line[0] != tc.argv0 {
+				t.Fatalf("command line %q, want it to start with %q", line, tc.argv0)
+			}
+			want := map[string]bool{
+				"TEST_SECRET=<redacted>":       true,
+				"TEST_PUBLIC=public":           true,
+				"TEST_MAGIC_COOKIE=<redacted>": true,
+			}
+			for _, kv := range c.CommandEnv() {
+				if strings.Contains(kv, "secret") || kv == "TEST_MAGIC_COOKIE=test" {
+					t.Fatalf("unredacted %q", kv)
+				}
+				delete(want, kv)
+			}
+			if len(want) > 0 {
+				t.Fatalf("missing %v from the environment", want)
+			}
+		})
+	}
+}
+
+func TestClientStart_handshakeSettle(t *testing.T) {
+	cases := []struct {
+		name   string
+		settle time.Duration
+		stale  bool
+	}{
+		{"disabled", 0, true},
+		{"superseded", time.Second, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := testClientConfig("superseded")
+			config.HandshakeSettleTime = tc.settle
+			c := testStartClient(t, config)
+
+			if stale := c.ReattachConfig().Addr.String() == testStaleAddr; stale != tc.stale {
+				t.Fatalf("connected to the stale handshake: %t, want %t", stale, tc.stale)
+			}
+		})
+	}
+}
+
+func TestClientStart_multiplexHandshakes(t *testing.T) {
+	cases := []struct {
+		mode   string
+		share  bool
+		mtls   bool
+		err    string
+		shared bool
+	}{
+		{mode: "multiplex"},
+		{mode: "multiplex", mtls: true},
+		{mode: "multiplex", share: true},
+		{mode: "multiplex", share: true, mtls: true},
+		{mode: "multiplex-shared", share: true, shared: true},
+		{mode: "multiplex-shared", share: true, mtls: true, shared: true},
+		{mode: "multiplex-missing", err: "missing: b"},
+		{mode: "multiplex-unnamed", err: "does not name the plugin"},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s share=%t mtls=%t", tc.mode, tc.share, tc.mtls), func(t *testing.T) {
+			config := testClientConfig(tc.mode)
+			config.VersionedPlugins = map[int]PluginSet{1: testMultiplexPluginSet}
+			config.MultiplexHandshakes = true
+			config.ShareConnections = tc.share
+			config.AutoMTLS = tc.mtls
+			config.StartTimeout = 2 * time.Second
+			c := NewClient(config)
+			defer c.Kill()
+
+			_, err := c.Start()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected an error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+
+			client, err := c.Client()
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if err := client.Ping(); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			a, err := client.Dispense("a")
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			b, err := client.Dispense("b")
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if shared := a == b; shared != tc.shared {
+				t.Fatalf("plugins dispensed over the same connection: %t, want %t", shared, tc.shared)
+			}
+		})
+	}
+}
+
+func TestSplitHandshake(t *testing.T) {
+	cases := []struct {
+		name string
+		line string
+		want []string
+		// plugin is the name of the plugin for MultiplexHandshakes.
+		plugin string
+	}{
+		{
+			name: "current",
+			line: "1|2|tcp|127.0.0.1:1234|grpc|CERT|v1.2.0\n",
+			want: []string{"1", "2", "tcp", "127.0.0.1:1234", "grpc", "CERT", "v1.2.0"},
+		},
+		{
+			name:   "current with plugin name",
+			line:   "1|2|tcp|127.0.0.1:1234|grpc|CERT|v1.2.0|kv",
+			want:   []string{"1", "2", "tcp", "127.0.0.1:1234", "grpc", "CERT", "v1.2.0", "kv"},
+			plugin: "kv",
+		},
+		{
+			name:   "plugin name containing the separator",
+			line:   "1|2|tcp|127.0.0.1:1234|grpc||v1.2.0|kv|v2",
+			want:   []string{"1", "2", "tcp", "127.0.0.1:1234", "grpc", "", "v1.2.0", "kv|v2"},
+			plugin: "kv|v2",
+		},
+		{
+			name: "without certificate and library version",
+			line: "1|2|tcp|127.0.0.1:1234|grpc",
+			want: []string{"1", "2", "tcp", "127.0.0.1:1234", "grpc"},
+		},
+		{
+			name: "legacy",
+			line: "1|2|tcp|127.0.0.1:1234|CERT",
+			want: []string{"1", "2", "tcp", "127.0.0.1:1234", "grpc", "CERT"},
+		},
+		{
+			name: "legacy without certificate",
+			line: "1|2|unix|/tmp/plugin.sock|",
+			want: []string{"1", "2", "unix", "/tmp/plugin.sock", "grpc", ""},
+		},
+		{
+			name: "address only",
+			line: "1|2|tcp|127.0.0.1:1234",
+			want: []string{"1", "2", "tcp", "127.0.0.1:1234"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := splitHandshake(tc.line); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("splitHandshake(%q) = %q, want %q", tc.line, got, tc.want)
+			}
+			if got := handshakePluginName(tc.line); got != tc.plugin {
+				t.Fatalf("handshakePluginName(%q) = %q, want %q", tc.line, got, tc.plugin)
+			}
+		})
+	}
+}
+
+func TestClientStart_legacyHandshake(t *testing.T) {
+	for _, mtls := range []bool{false, true} {
+		t.Run(fmt.Sprintf("mtls=%t", mtls), func(t *testing.T) {
+			config := testClientConfig("legacy")
+			config.AutoMTLS = mtls
+			c := NewClient(config)
+			defer c.Kill()
+
+			if _, err := c.Start(); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if got := c.PluginLibraryVersion(); got != "" {
+				t.Fatalf("plugin library version %q, want none", got)
+			}
+			if got := c.serverCert.Load() != nil; got != mtls {
+				t.Fatalf("server certificate loaded: %t, want %t", got, mtls)
+			}
+			testDispense(t, c)
+		})
+	}
+}
+
+func TestClientStart_multiplexCertExpired(t *testing.T) {
+	config := testClientConfig("multiplex")
+	config.VersionedPlugins = map[int]PluginSet{1: testMultiplexPluginSet}
+	config.MultiplexHandshakes = true
+	config.AutoMTLS = true
+	c := NewClient(config)
+	defer c.Kill()
+
+	if _, err := c.Start(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The certificate expires after the client connected, so gRPC
+	// reconnecting must not reach the plugin any more.
+	expired := *c.serverCert.Load()
+	expired.NotAfter = time.Now().Add(-time.Minute)
+	c.serverCert.Store(&expired)
+
+	c.m.Lock()
+	client, err := newMultiplexedClient(c.doneCtx, c)
+	c.m.Unlock()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer client.disconnect()
+
+	err = client.Ping()
+	if err == nil || !strings.Contains(err.Error(), ErrServerCertExpired.Error()) {
+		t.Fatalf("expected an error containing %q, got %v", ErrServerCertExpired, err)
+	}
+}
+
+func TestClientStart_partialHandshake(t *testing.T) {
+	// Either way the error reports the partial handshake, but only once the
+	// start timed out if the check is disabled.
+	cases := []struct {
+		name    string
+		timeout time.Duration
+		early   bool
+	}{
+		{"detected", 200 * time.Millisecond, true},
+		{"disabled", 0, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := testClientConfig("partial-handshake")
+			config.PartialHandshakeTimeout = tc.timeout
+			config.StartTimeout = 2 * time.Second
+			c := NewClient(config)
+			defer c.Kill()
+
+			start := time.Now()
+			_, err := c.Start()
+			if !errors.Is(err, ErrPartialHandshake) {
+				t.Fatalf("expected ErrPartialHandshake, got %v", err)
+			}
+			if early := time.Since(start) < config.StartTimeout; early != tc.early {
+				t.Fatalf("failed before the start timeout: %t, want %t", early, tc.early)
+			}
+		})
+	}
+}
@@ -13,7 +13,8 @@ import (
 )
 
 var (
-	_ runner.Runner = (*CmdRunner)(nil)
+	_ runner.Runner   = (*CmdRunner)(nil)
+	_ runner.Signaler = (*CmdRunner)(nil)
 
 	// ErrProcessNotFound is returned when a client is instantiated to
 	// reattach to an existing process and it isn't found.
@@ -97,6 +98,17 @@ func (c *CmdRunner) Kill(_ context.Context) error {
 	return nil
 }
 
+func (c *CmdRunner) Signal(sig os.Signal) error {
+	if c.cmd.Process == nil {
+		return nil
+	}
+	err := c.cmd.Process.Signal(sig)
+	if errors.Is(err, os.ErrProcessDone) {
+		return nil
+	}
+	return err
+}
+
 func (c *CmdRunner) Stdout() io.ReadCloser { return c.stdout }
 
 func (c *CmdRunner) Stderr() io.ReadCloser { return c.stderr }
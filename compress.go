@@ -0,0 +1,163 @@
+package plugin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// adaptiveCompressorName is the grpc-encoding of messages compressed with
+// ClientConfig.CompressThreshold.
+const adaptiveCompressorName = "plugin-adaptive-gzip"
+
+// defaultCompressThreshold is the threshold a plugin compresses its
+// responses above when the host compresses its requests but didn't tell
+// the plugin its threshold, e.g. because it reattached to it.
+const defaultCompressThreshold = 1024
+
+// Every message compressed by adaptiveCompressor starts with a byte telling
+// whether the rest of it is gzip compressed. gRPC marks all messages of a
+// call as compressed, so this is what lets small ones be sent as is.
+const (
+	adaptiveRaw  byte = 0
+	adaptiveGzip byte = 1
+)
+
+// adaptive is the registered adaptiveCompressor, which compresses and
+// decompresses the messages of every connection of the process.
+var adaptive = newAdaptiveCompressor(defaultCompressThreshold)
+
+func init() {
+	encoding.RegisterCompressor(adaptive)
+}
+
+// adaptiveCompressor gzip compresses the messages of at least threshold
+// bytes and sends smaller ones uncompressed. Compressors are registered by
+// name for the whole process, so the threshold is shared by all the
+// connections using it.
+type adaptiveCompressor struct {
+	threshold atomic.Int64
+}
+
+func newAdaptiveCompressor(threshold int) *adaptiveCompressor {
+	c := &adaptiveCompressor{}
+	c.threshold.Store(int64(threshold))
+	return c
+}
+
+// setThreshold sets the size in bytes messages are compressed above.
+func (c *adaptiveCompressor) setThreshold(threshold int) {
+	c.threshold.Store(int64(threshold))
+}
+
+var gzipWriterPool = sync.Pool{
+	New: func() any { return gzip.NewWriter(io.Discard) },
+}
+
+// Name implements encoding.Compressor.
+func (c *adaptiveCompressor) Name() string {
+	return adaptiveCompressorName
+}
+
+// Compress implements encoding.Compressor. The message is buffered, since
+// whether it is compressed depends on its size.
+func (c *adaptiveCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return &adaptiveWriter{threshold: int(c.threshold.Load()), w: w}, nil
+}
+
+// Decompress implements encoding.Compressor.
+func (c *adaptiveCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	var marker [1]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil {
+		return nil, err
+	}
+	switch marker[0] {
+	case adaptiveRaw:
+		return r, nil
+	case adaptiveGzip:
+		return gzip.NewReader(r)
+	}
+	return nil, fmt.Errorf("unknown %s message marker %d", adaptiveCompressorName, marker[0])
+}
+
+// adaptiveWriter buffers a message until it is closed, then writes it
+// compressed if it has at least threshold bytes.
+type adaptiveWriter struct {
+	threshold int
+	w         io.Writer
+	buf       bytes.Buffer
+}
+
+func (w *adaptiveWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *adaptiveWriter) Close() error {
+	p := w.buf.Bytes()
+	if len(p) < w.threshold {
+		if _, err := w.w.Write([]byte{adaptiveRaw}); err != nil {
+			return err
+		}
+		_, err := w.w.Write(p)
+		return err
+	}
+
+	if _, err := w.w.Write([]byte{adaptiveGzip}); err != nil {
+		return err
+	}
+	z := gzipWriterPool.Get().(*gzip.Writer)
+	defer gzipWriterPool.Put(z)
+	z.Reset(w.w)
+	if _, err := z.Write(p); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// compressThresholdFromEnv returns the threshold set by the client with
+// ClientConfig.CompressThreshold, or zero if it isn't set.
+func compressThresholdFromEnv() (int, error) {
+	v := os.Getenv(EnvCompressThreshold)
+	if v == "" {
+		return 0, nil
+	}
+	threshold, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", EnvCompressThreshold, err)
+	}
+	return threshold, nil
+}
+
+// compressDialOption returns the dial option compressing the requests with
+// threshold.
+func compressDialOption(threshold int) grpc.DialOption {
+	adaptive.setThreshold(threshold)
+	return grpc.WithDefaultCallOptions(grpc.UseCompressor(adaptiveCompressorName))
+}
+
+// compressServerOptions returns the server options compressing the
+// responses of a server with threshold, including those to hosts that
+// don't compress their requests, as long as they accept compressed
+// responses.
+func compressServerOptions(threshold int) []grpc.ServerOption {
+	adaptive.setThreshold(threshold)
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			grpc.SetSendCompressor(ctx, adaptiveCompressorName)
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			grpc.SetSendCompressor(ss.Context(), adaptiveCompressorName)
+			return handler(srv, ss)
+		}),
+	}
+}
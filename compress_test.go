@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestAdaptiveCompressor(t *testing.T) {
+	cases := []struct {
+		name      string
+		threshold int
+		size      int
+		marker    byte
+	}{
+		{"below threshold", 1024, 1023, adaptiveRaw},
+		{"at threshold", 1024, 1024, adaptiveGzip},
+		{"above threshold", 1024, 4096, adaptiveGzip},
+		{"empty", 1024, 0, adaptiveRaw},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newAdaptiveCompressor(tc.threshold)
+			msg := bytes.Repeat([]byte("a"), tc.size)
+
+			var buf bytes.Buffer
+			w, err := c.Compress(&buf)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if _, err := w.Write(msg); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if got := buf.Bytes()[0]; got != tc.marker {
+				t.Fatalf("marker %d, want %d", got, tc.marker)
+			}
+
+			r, err := c.Decompress(&buf)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if !bytes.Equal(got, msg) {
+				t.Fatalf("decompressed %d bytes, want %d", len(got), len(msg))
+			}
+		})
+	}
+}
+
+func TestAdaptiveCompressor_unknownMarker(t *testing.T) {
+	c := newAdaptiveCompressor(defaultCompressThreshold)
+	if _, err := c.Decompress(bytes.NewReader([]byte{2, 'a'})); err == nil {
+		t.Fatal("expected an error for an unknown marker")
+	}
+}
+
+func TestClientCompressThreshold(t *testing.T) {
+	config := testClientConfig("test-grpc")
+	config.CompressThreshold = 1
+	c := testStartClient(t, config)
+
+	client, err := c.Client()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := client.Ping(); err != nil {
+		t.Fatalf("ping with compressed messages: %s", err)
+	}
+}
@@ -0,0 +1,110 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// CompressedBinary is configuration for running a plugin that is shipped as
+// a compressed executable. The client decompresses it into a temporary
+// directory and runs the decompressed binary, which is what
+// ClientConfig.SecureConfig and Provenance verify. The temporary copy is
+// removed once the plugin exited or was killed.
+type CompressedBinary struct {
+	// Path is the path to the compressed executable. ClientConfig.Cmd still
+	// provides the arguments and environment, but its Path is replaced with
+	// the decompressed binary.
+	Path string
+
+	// Decompress, if set, wraps the compressed stream with a decompressor.
+	// gzip is supported out of the box; zstd compressed plugins need a
+	// Decompress function, e.g. built on github.com/klauspost/compress/zstd,
+	// so that the dependency stays optional:
+	//
+	//	Decompress: func(r io.Reader) (io.ReadCloser, error) {
+	//		d, err := zstd.NewReader(r)
+	//		if err != nil {
+	//			return nil, err
+	//		}
+	//		return d.IOReadCloser(), nil
+	//	}
+	Decompress func(r io.Reader) (io.ReadCloser, error)
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompressor returns the decompressing reader of r.
+func (b *CompressedBinary) decompressor(r *bufio.Reader) (io.ReadCloser, error) {
+	if b.Decompress != nil {
+		return b.Decompress(r)
+	}
+
+	magic, _ := r.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(r)
+	case bytes.HasPrefix(magic, zstdMagic):
+		return nil, errors.New("zstd compressed plugins require CompressedBinary.Decompress to be set")
+	}
+	return nil, errors.New("unknown compression format, CompressedBinary.Decompress must be set")
+}
+
+// extract decompresses the binary into a new temporary directory and
+// returns the directory and the path of the executable inside it.
+func (b *CompressedBinary) extract() (dir, path string, err error) {
+	f, err := os.Open(b.Path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	r, err := b.decompressor(bufio.NewReader(f))
+	if err != nil {
+		return "", "", fmt.Errorf("error decompressing %s: %w", b.Path, err)
+	}
+	defer r.Close()
+
+	dir, err = os.MkdirTemp("", "plugin-bin")
+	if err != nil {
+		return "", "", err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	path = filepath.Join(dir, decompressedName(b.Path))
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o700)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err = io.Copy(out, r); err != nil {
+		out.Close()
+		return "", "", fmt.Errorf("error decompressing %s: %w", b.Path, err)
+	}
+	if err = out.Close(); err != nil {
+		return "", "", err
+	}
+	return dir, path, nil
+}
+
+// decompressedName returns the name of the executable compressed at path,
+// i.e. its base name without the compression extension.
+func decompressedName(path string) string {
+	name := filepath.Base(path)
+	switch ext := filepath.Ext(name); ext {
+	case ".gz", ".gzip", ".zst", ".zstd":
+		name = name[:len(name)-len(ext)]
+	}
+	return name
+}
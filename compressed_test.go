@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCompressBinary writes the test binary, which is also the helper
+// plugin, compressed with gzip to dir.
+func testCompressBinary(t *testing.T, dir string) string {
+	t.Helper()
+	in, err := os.Open(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	path := filepath.Join(dir, "plugin.gz")
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	z, _ := gzip.NewWriterLevel(out, gzip.BestSpeed)
+	if _, err := io.Copy(z, in); err != nil {
+		t.Fatal(err)
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestClientCompressedBinary(t *testing.T) {
+	dir := t.TempDir()
+	gz := testCompressBinary(t, dir)
+	zst := filepath.Join(dir, "plugin.zst")
+	if err := os.WriteFile(zst, append(zstdMagic, 0), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		binary *CompressedBinary
+		err    string
+	}{
+		{"gzip", &CompressedBinary{Path: gz}, ""},
+		{"custom decompressor", &CompressedBinary{
+			Path:       os.Args[0],
+			Decompress: func(r io.Reader) (io.ReadCloser, error) { return io.NopCloser(r), nil },
+		}, ""},
+		{"zstd without decompressor", &CompressedBinary{Path: zst}, "require CompressedBinary.Decompress"},
+		{"unknown format", &CompressedBinary{Path: os.Args[0]}, "unknown compression format"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := testClientConfig("test-grpc")
+			config.CompressedBinary = tc.binary
+			c := NewClient(config)
+			defer c.Kill()
+
+			_, err := c.Start()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected an error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+
+			extracted := c.extractedDir
+			if _, err := os.Stat(filepath.Join(extracted, decompressedName(tc.binary.Path))); err != nil {
+				t.Fatalf("decompressed plugin binary: %s", err)
+			}
+			c.Kill()
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				if _, err := os.Stat(extracted); os.IsNotExist(err) {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("the decompressed plugin binary was not removed")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestDecompressedName(t *testing.T) {
+	cases := map[string]string{
+		"/plugins/plugin.gz":   "plugin",
+		"/plugins/plugin.gzip": "plugin",
+		"/plugins/plugin.zst":  "plugin",
+		"/plugins/plugin.zstd": "plugin",
+		"/plugins/plugin":      "plugin",
+		"/plugins/plugin.v1":   "plugin.v1",
+	}
+	for path, want := range cases {
+		if got := decompressedName(path); got != want {
+			t.Errorf("decompressedName(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrConfigMutated is returned by Start when ClientConfig.DetectConfigMutation
+// is set and the config was modified after it was passed to NewClient.
+var ErrConfigMutated = errors.New("client config was modified after NewClient")
+
+// configFingerprint hashes the fields of config that determine which plugin
+// is started and how the client talks to it. Pointer fields, such as the
+// TLS config, are compared by identity.
+func configFingerprint(config *ClientConfig) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "handshake:%s|%s\n", config.MagicCookieKey, config.MagicCookieValue)
+
+	versions := make([]int, 0, len(config.VersionedPlugins))
+	for v := range config.VersionedPlugins {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	for _, v := range versions {
+		names := make([]string, 0, len(config.VersionedPlugins[v]))
+		for name := range config.VersionedPlugins[v] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(&b, "plugins:%d:%q\n", v, names)
+	}
+
+	if cmd := config.Cmd; cmd != nil {
+		fmt.Fprintf(&b, "cmd:%q|%q|%q|%q\n", cmd.Path, cmd.Args, cmd.Env, cmd.Dir)
+	}
+	if r := config.Reattach; r != nil {
+		fmt.Fprintf(&b, "reattach:%d|%v|%d|%t\n", r.ProtocolVersion, r.Addr, r.Pid, r.Test)
+	}
+	if s := config.SecureConfig; s != nil {
+		fmt.Fprintf(&b, "secure:%x|%t\n", s.Checksum, s.Concurrent)
+	}
+
+	fmt.Fprintf(&b, "pointers:%p|%p|%p|%p\n",
+		config.TLSConfig, config.BrokerTLSConfig, config.Provenance, config.Sandbox)
+	fmt.Fprintf(&b, "ports:%d|%d|%d\n", config.MinPort, config.MaxPort, config.FixedPort)
+	fmt.Fprintf(&b, "options:%t|%t|%t|%t|%s|%d\n",
+		config.AutoMTLS, config.MessageMAC, config.MultiplexHandshakes, config.Managed,
+		config.StartTimeout, len(config.GRPCDialOptions))
+
+	sum := sha256.Sum256(b.Bytes())
+	return sum[:]
+}
+
+// checkConfigMutation returns ErrConfigMutated if the config of c changed
+// since NewClient. It only checks once, since Start fills in parts of the
+// config itself.
+func (c *Client) checkConfigMutation() error {
+	if c.configFingerprint == nil {
+		return nil
+	}
+	want := c.configFingerprint
+	c.configFingerprint = nil
+
+	if !bytes.Equal(want, configFingerprint(c.config)) {
+		return ErrConfigMutated
+	}
+	return nil
+}
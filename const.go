@@ -8,4 +8,50 @@ const (
 	// EnvUnixSocketGroup specifies the owning, writable group to set for Unix
 	// sockets created by _plugins_. Does not affect client behavior.
 	EnvUnixSocketGroup = "PLUGIN_UNIX_SOCKET_GROUP"
+
+	// EnvUnixSocketPath specifies the exact path of the unix socket
+	// _plugins_ should listen on, instead of a random one. Set by the
+	// client from UnixSocketConfig.FixedName. Does not affect client
+	// behavior.
+	EnvUnixSocketPath = "PLUGIN_UNIX_SOCKET_PATH"
+
+	// EnvBindAddress specifies the IP address that _plugins_ should bind TCP
+	// listeners to. Defaults to 127.0.0.1. Does not affect client behavior.
+	EnvBindAddress = "PLUGIN_BIND_ADDRESS"
+
+	// EnvFixedPort specifies the exact TCP port that _plugins_ should listen
+	// on, instead of one in the PLUGIN_MIN_PORT-PLUGIN_MAX_PORT range. When
+	// set, plugins listen on TCP even on platforms that default to Unix
+	// sockets. Does not affect client behavior.
+	EnvFixedPort = "PLUGIN_FIXED_PORT"
+
+	// EnvForceTCP specifies that _plugins_ should listen on TCP even on
+	// platforms that default to Unix sockets. Set by the client from
+	// ClientConfig.ForceTCP. Does not affect client behavior.
+	EnvForceTCP = "PLUGIN_FORCE_TCP"
+
+	// EnvAutoMTLSKeyType specifies the CertKeyType of the certificate
+	// _plugins_ generate for AutoMTLS. Set by the client from
+	// ClientConfig.AutoMTLSCertConfig.
+	EnvAutoMTLSKeyType = "PLUGIN_AUTOMTLS_KEY_TYPE"
+
+	// EnvAutoMTLSNotAfter specifies the validity, as a Go duration, of the
+	// certificate _plugins_ generate for AutoMTLS. Set by the client from
+	// ClientConfig.AutoMTLSCertConfig.
+	EnvAutoMTLSNotAfter = "PLUGIN_AUTOMTLS_NOT_AFTER"
+
+	// EnvCompressThreshold specifies the size in bytes above which
+	// _plugins_ compress their responses. Set by the client from
+	// ClientConfig.CompressThreshold.
+	EnvCompressThreshold = "PLUGIN_COMPRESS_THRESHOLD"
+
+	// EnvHandshakePrefix specifies the marker _plugins_ start their
+	// handshake line with. Set by the client from
+	// ClientConfig.HandshakePrefix.
+	EnvHandshakePrefix = "PLUGIN_HANDSHAKE_PREFIX"
+
+	// EnvSandboxSeccomp specifies that _plugins_ should install the default
+	// seccomp profile before they serve. Set by the client from
+	// SandboxConfig.Seccomp.
+	EnvSandboxSeccomp = "PLUGIN_SANDBOX_SECCOMP"
 )
@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrCrashLooping is returned by Start once the plugin crashed more often
+// than allowed by RestartPolicy.CrashLoopLimit, after which the client is
+// no longer restarted.
+var ErrCrashLooping = errors.New("plugin is crash looping")
+
+// defaultCrashLoopWindow is the window of RestartPolicy.CrashLoopLimit if
+// RestartPolicy.CrashLoopWindow is not set.
+const defaultCrashLoopWindow = time.Minute
+
+// crashLoopExit is an unexpected exit of the plugin.
+type crashLoopExit struct {
+	at  time.Time
+	err error
+}
+
+// recordCrash records an unexpected exit of the plugin with AutoRestart set.
+// If the plugin crashed more than CrashLoopLimit times within the window,
+// it marks the client as crash looping and returns the exit errors in the
+// window. The lock must be held.
+func (c *Client) recordCrash(err error) ([]error, bool) {
+	policy := c.config.AutoRestart
+	if policy.CrashLoopLimit <= 0 {
+		return nil, false
+	}
+	window := policy.CrashLoopWindow
+	if window <= 0 {
+		window = defaultCrashLoopWindow
+	}
+
+	now := time.Now()
+	exits := c.crashes[:0]
+	for _, exit := range c.crashes {
+		if now.Sub(exit.at) < window {
+			exits = append(exits, exit)
+		}
+	}
+	c.crashes = append(exits, crashLoopExit{at: now, err: err})
+
+	if len(c.crashes) <= policy.CrashLoopLimit {
+		return nil, false
+	}
+
+	c.crashLooping = true
+	errs := make([]error, 0, len(c.crashes))
+	for _, exit := range c.crashes {
+		errs = append(errs, exit.err)
+	}
+	return errs, true
+}
+
+// IsCrashLooping reports whether the plugin crashed more often than allowed
+// by RestartPolicy.CrashLoopLimit, in which case it is not restarted
+// anymore and Start returns ErrCrashLooping.
+func (c *Client) IsCrashLooping() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.crashLooping
+}
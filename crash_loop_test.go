@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRestartPolicyDelay(t *testing.T) {
+	cases := []struct {
+		name    string
+		policy  RestartPolicy
+		attempt int
+		want    time.Duration
+	}{
+		{"default", RestartPolicy{}, 0, 100 * time.Millisecond},
+		{"doubled", RestartPolicy{}, 3, 800 * time.Millisecond},
+		{"default cap", RestartPolicy{}, 20, 30 * time.Second},
+		{"backoff", RestartPolicy{Backoff: time.Second}, 1, 2 * time.Second},
+		{"max backoff", RestartPolicy{Backoff: time.Second, MaxBackoff: 3 * time.Second}, 2, 3 * time.Second},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.policy.delay(tc.attempt); got != tc.want {
+				t.Fatalf("delay %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClientRecordCrash(t *testing.T) {
+	old := time.Now().Add(-2 * time.Minute)
+	cases := []struct {
+		name     string
+		limit    int
+		previous []crashLoopExit
+		looping  bool
+	}{
+		{"disabled", 0, []crashLoopExit{{at: time.Now()}, {at: time.Now()}}, false},
+		{"within the limit", 2, []crashLoopExit{{at: time.Now()}}, false},
+		{"over the limit", 2, []crashLoopExit{{at: time.Now()}, {at: time.Now()}}, true},
+		{"outside the window", 2, []crashLoopExit{{at: old}, {at: old}}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewClient(&ClientConfig{
+				Logger:      testLogger(),
+				AutoRestart: &RestartPolicy{CrashLoopLimit: tc.limit},
+			})
+			c.crashes = tc.previous
+
+			errs, looping := c.recordCrash(errors.New("crashed"))
+			if looping != tc.looping || c.IsCrashLooping() != tc.looping {
+				t.Fatalf("crash looping %t, want %t", looping, tc.looping)
+			}
+			if looping && len(errs) != tc.limit+1 {
+				t.Fatalf("%d crash errors, want %d", len(errs), tc.limit+1)
+			}
+		})
+	}
+}
+
+func TestClientAutoRestart_crashLoop(t *testing.T) {
+	crashLoop := make(chan []error, 1)
+	config := testClientConfig("crash")
+	config.AutoRestart = &RestartPolicy{
+		Backoff:        10 * time.Millisecond,
+		CrashLoopLimit: 2,
+	}
+	config.OnCrashLoop = func(errs []error) { crashLoop <- errs }
+	c := testStartClient(t, config)
+
+	select {
+	case errs := <-crashLoop:
+		if len(errs) != 3 {
+			t.Fatalf("%d crash errors, want 3", len(errs))
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("the crash loop was not detected")
+	}
+	if !c.IsCrashLooping() {
+		t.Fatal("client is not crash looping")
+	}
+	if _, err := c.Start(); !errors.Is(err, ErrCrashLooping) {
+		t.Fatalf("expected ErrCrashLooping, got %v", err)
+	}
+}
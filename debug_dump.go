@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/kform-dev/plugin/internal/plugin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrDebugDumpUnsupported is returned by Client.DebugDump when the plugin
+// doesn't set ServeConfig.DebugDumper.
+var ErrDebugDumpUnsupported = errors.New("plugin does not support debug dumps")
+
+// DebugDumper is implemented by plugins that can report a snapshot of their
+// internal state, e.g. their config, counters and goroutine count, when the
+// host calls Client.DebugDump.
+type DebugDumper interface {
+	DebugDump(ctx context.Context) (map[string]string, error)
+}
+
+// DebugDumperFunc adapts a function to a DebugDumper.
+type DebugDumperFunc func(ctx context.Context) (map[string]string, error)
+
+// DebugDump implements DebugDumper.
+func (f DebugDumperFunc) DebugDump(ctx context.Context) (map[string]string, error) {
+	return f(ctx)
+}
+
+// DebugDump asks the plugin for a snapshot of its internal state. It returns
+// ErrDebugDumpUnsupported if the plugin doesn't implement DebugDumper. For a
+// process started with ClientConfig.MultiplexHandshakes, the keys are
+// prefixed with the name of the plugin they were reported by and a dot.
+func (c *Client) DebugDump(ctx context.Context) (map[string]string, error) {
+	client, err := c.protocolClient()
+	if err != nil {
+		return nil, err
+	}
+
+	switch client := client.(type) {
+	case *GRPCClient:
+		return debugDump(ctx, client.controller)
+	case *multiplexedClient:
+		dump := make(map[string]string)
+		for name, cl := range client.clients {
+			values, err := debugDump(ctx, cl.controller)
+			if err != nil {
+				return nil, fmt.Errorf("plugin %q: %w", name, err)
+			}
+			for k, v := range values {
+				dump[name+"."+k] = v
+			}
+		}
+		return dump, nil
+	default:
+		return nil, ErrDebugDumpUnsupported
+	}
+}
+
+// debugDump calls the DebugDump RPC of the controller.
+func debugDump(ctx context.Context, controller plugin.GRPCControllerClient) (map[string]string, error) {
+	resp, err := controller.DebugDump(ctx, &plugin.Empty{})
+	if status.Code(err) == codes.Unimplemented {
+		return nil, ErrDebugDumpUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Values, nil
+}
@@ -0,0 +1,101 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// DebugHandlerPath is the path DebugHandler is intended to be mounted at:
+//
+//	mux.Handle(plugin.DebugHandlerPath, plugin.DebugHandler())
+const DebugHandlerPath = "/debug/plugins"
+
+// debugPingTimeout bounds the health probe of each plugin, so a hung plugin
+// doesn't hang the handler.
+var debugPingTimeout = 2 * time.Second
+
+// clientDebugInfo is the summary of a managed client served by DebugHandler.
+type clientDebugInfo struct {
+	Name            string `json:"name"`
+	ID              string `json:"id"`
+	Uptime          string `json:"uptime,omitempty"`
+	Health          string `json:"health"`
+	Restarts        int    `json:"restarts"`
+	Transport       string `json:"transport,omitempty"`
+	Address         string `json:"address,omitempty"`
+	ProtocolVersion int    `json:"protocol_version,omitempty"`
+}
+
+// DebugHandler returns an http.Handler that serves a JSON summary of all
+// managed clients (see ClientConfig.Managed): their plugin name, id, uptime,
+// health, restart count and transport.
+//
+// The health of a client is only probed if it has an open RPC connection;
+// the handler never dials a plugin itself. A plugin that doesn't respond
+// within a couple of seconds is reported as unhealthy.
+func DebugHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		managedClientsLock.Lock()
+		clients := append([]*Client(nil), managedClients...)
+		managedClientsLock.Unlock()
+
+		infos := make([]clientDebugInfo, 0, len(clients))
+		for _, c := range clients {
+			infos = append(infos, c.debugInfo(r.Context()))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(infos); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+func (c *Client) debugInfo(ctx context.Context) clientDebugInfo {
+	c.m.Lock()
+	info := clientDebugInfo{
+		Restarts:        c.restartCount,
+		ProtocolVersion: c.negotiatedVersion,
+	}
+	if c.runner != nil {
+		info.ID = c.runner.ID()
+		if r, ok := c.runner.(interface{ Name() string }); ok {
+			info.Name = r.Name()
+		}
+	}
+	if !c.startTime.IsZero() {
+		info.Uptime = time.Since(c.startTime).Round(time.Second).String()
+	}
+	if c.address != nil {
+		info.Transport = c.address.Network()
+		info.Address = c.address.String()
+	}
+	exited := c.exited
+	client := c.client
+	c.m.Unlock()
+
+	switch {
+	case exited:
+		info.Health = "exited"
+	case client == nil:
+		info.Health = "not connected"
+	default:
+		ctx, cancel := context.WithTimeout(ctx, debugPingTimeout)
+		defer cancel()
+		err := checkClient(ctx, client)
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			info.Health = "unhealthy: no response within " + debugPingTimeout.String()
+		case err != nil:
+			info.Health = "unhealthy: " + err.Error()
+		default:
+			info.Health = "serving"
+		}
+	}
+	return info
+}
@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientDebugInfo_health(t *testing.T) {
+	defer func(timeout time.Duration) { debugPingTimeout = timeout }(debugPingTimeout)
+	debugPingTimeout = 50 * time.Millisecond
+
+	hung := &hangingClient{release: make(chan struct{})}
+	defer close(hung.release)
+
+	cases := []struct {
+		name   string
+		client func(t *testing.T) *Client
+		health string
+	}{
+		{"serving", func(t *testing.T) *Client {
+			c := testStartClient(t, testClientConfig("test-grpc"))
+			if _, err := c.Client(); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			return c
+		}, "serving"},
+		{"not serving", func(t *testing.T) *Client {
+			c := testStartClient(t, testClientConfig("not-serving"))
+			if _, err := c.Client(); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			return c
+		}, "unhealthy: plugin is NOT_SERVING"},
+		{"not connected", func(t *testing.T) *Client {
+			return NewClient(&ClientConfig{Logger: testLogger()})
+		}, "not connected"},
+		{"hung", func(t *testing.T) *Client {
+			c := NewClient(&ClientConfig{Logger: testLogger()})
+			c.client = hung
+			return c
+		}, "unhealthy: no response within"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := tc.client(t)
+
+			done := make(chan clientDebugInfo)
+			go func() { done <- c.debugInfo(context.Background()) }()
+			select {
+			case info := <-done:
+				if !strings.HasPrefix(info.Health, tc.health) {
+					t.Fatalf("health %q, want %q", info.Health, tc.health)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("debugInfo did not return")
+			}
+		})
+	}
+}
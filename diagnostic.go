@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNoAvailablePort is returned when the plugin cannot bind any port in
+// the range configured with ClientConfig.MinPort and MaxPort.
+var ErrNoAvailablePort = errors.New("no available port in the plugin port range")
+
+// diagnosticPrefix marks a stderr line written by the plugin server that
+// carries a JSON encoded StartupDiagnostic. The client recognizes these lines
+// while reading the plugin's stderr and surfaces them in the startup error.
+const diagnosticPrefix = "plugin-diagnostic: "
+
+// Startup diagnostic stages.
+const (
+	// DiagnosticStageListen is reported when the plugin cannot create the
+	// listener it would advertise in the handshake.
+	DiagnosticStageListen = "listen"
+
+	// DiagnosticStageConfig is reported when the configuration passed to the
+	// plugin by the client is invalid.
+	DiagnosticStageConfig = "config"
+
+	// DiagnosticStageSandbox is reported when the plugin cannot install the
+	// seccomp profile requested with SandboxConfig.Seccomp.
+	DiagnosticStageSandbox = "sandbox"
+)
+
+// Startup diagnostic codes.
+const (
+	// DiagnosticCodeNoAvailablePort is reported when every port in the
+	// configured range is in use.
+	DiagnosticCodeNoAvailablePort = "no-available-port"
+)
+
+// StartupDiagnostic describes why a plugin server failed before it could
+// complete the handshake. It is written to stderr by the plugin and parsed by
+// the client, which returns it as part of the error from Start.
+type StartupDiagnostic struct {
+	// Stage is the startup stage that failed, e.g. DiagnosticStageListen.
+	Stage string `json:"stage"`
+
+	// Network is the network the plugin attempted to use, if known.
+	Network string `json:"network,omitempty"`
+
+	// Code identifies well-known failures, e.g.
+	// DiagnosticCodeNoAvailablePort.
+	Code string `json:"code,omitempty"`
+
+	// Message is the error reported by the plugin.
+	Message string `json:"message"`
+}
+
+func (d *StartupDiagnostic) Error() string {
+	msg := fmt.Sprintf("plugin %s failed", d.Stage)
+	if d.Network != "" {
+		msg += fmt.Sprintf(" (%s)", d.Network)
+	}
+	return msg + ": " + d.Message
+}
+
+// Unwrap returns the error matching the diagnostic code, if any, so callers
+// can use errors.Is(err, ErrNoAvailablePort).
+func (d *StartupDiagnostic) Unwrap() error {
+	switch d.Code {
+	case DiagnosticCodeNoAvailablePort:
+		return ErrNoAvailablePort
+	}
+	return nil
+}
+
+// diagnosticCode returns the diagnostic code for an error.
+func diagnosticCode(err error) string {
+	switch {
+	case errors.Is(err, ErrNoAvailablePort):
+		return DiagnosticCodeNoAvailablePort
+	}
+	return ""
+}
+
+// writeDiagnostic writes the diagnostic to w as a single prefixed line.
+func writeDiagnostic(w io.Writer, d *StartupDiagnostic) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", diagnosticPrefix, b)
+	return err
+}
+
+// parseDiagnostic returns the diagnostic carried by a stderr line, if any.
+func parseDiagnostic(line []byte) (*StartupDiagnostic, bool) {
+	if !bytes.HasPrefix(line, []byte(diagnosticPrefix)) {
+		return nil, false
+	}
+
+	d := &StartupDiagnostic{}
+	if err := json.Unmarshal(line[len(diagnosticPrefix):], d); err != nil {
+		return nil, false
+	}
+	return d, true
+}
@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+)
+
+// DialContext returns a dialer that connects to the plugin with the same
+// address and transport security as the client, e.g. to build a gRPC client
+// for a service that is not modeled as a Plugin:
+//
+//	conn, err := grpc.NewClient("passthrough:///plugin",
+//		grpc.WithContextDialer(client.DialContext()),
+//		grpc.WithTransportCredentials(insecure.NewCredentials()))
+//
+// The returned connections already completed the TLS handshake, if TLS is
+// used, so they must be used with insecure gRPC credentials. The target is
+// ignored. The dialer fails until the client has been started.
+func (c *Client) DialContext() func(ctx context.Context, target string) (net.Conn, error) {
+	return func(ctx context.Context, _ string) (net.Conn, error) {
+		c.m.Lock()
+		addr := c.address
+		tlsConfig := c.config.TLSConfig
+		c.m.Unlock()
+
+		if addr == nil {
+			return nil, errors.New("plugin is not started")
+		}
+		if err := c.checkServerCert(); err != nil {
+			return nil, err
+		}
+		if c.config.Discovery != nil {
+			var err error
+			if addr, err = c.resolveAddr(0); err != nil {
+				return nil, err
+			}
+		}
+
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, addr.Network(), addr.String())
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig == nil {
+			return conn, nil
+		}
+
+		if c.config.ExpectedSPIFFEID != "" {
+			if tlsConfig, err = withSPIFFEVerification(tlsConfig, c.config.ExpectedSPIFFEID); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		} else {
+			tlsConfig = tlsConfig.Clone()
+		}
+		if len(tlsConfig.NextProtos) == 0 {
+			// gRPC servers negotiate HTTP/2 with ALPN.
+			tlsConfig.NextProtos = []string{"h2"}
+		}
+
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ErrDiscoveryAndLocal is returned when Discovery is set together with an
+// option that only applies to plugins the client launches itself.
+var ErrDiscoveryAndLocal = errors.New("Discovery can not be used with SecureConfig, Provenance, Sandbox, CompressedBinary, Singleton or AutoMTLS")
+
+// DiscoveryConfig is configuration for connecting to a remote plugin that is
+// registered in a service discovery system, such as Consul, etcd or DNS SRV
+// records, instead of launching it or reattaching to a fixed address.
+type DiscoveryConfig struct {
+	// ServiceName is the name the plugin is registered under.
+	ServiceName string
+
+	// Resolve returns the address of an instance of the service. It is
+	// called when the client starts, and again every time the connection to
+	// the plugin is re-established, so a restarted or moved instance is
+	// found.
+	Resolve func(ctx context.Context, serviceName string) (net.Addr, error)
+
+	// ProtocolVersion is the protocol version the remote plugin serves.
+	ProtocolVersion int
+}
+
+// discover resolves the address of the plugin configured with
+// ClientConfig.Discovery. The plugin is not owned by the client, so Kill only
+// closes the connection to it.
+func (c *Client) discover() (net.Addr, error) {
+	d := c.config.Discovery
+	if d.Resolve == nil {
+		return nil, errors.New("Discovery.Resolve must be set")
+	}
+	// AutoMTLS needs the certificate the plugin prints when launched, so
+	// without it the connection would silently fall back to plaintext.
+	// Remote plugins are secured with TLSConfig instead.
+	if c.config.SecureConfig != nil || c.config.Provenance != nil || c.config.Sandbox != nil ||
+		c.config.CompressedBinary != nil || c.config.Singleton || c.config.AutoMTLS {
+		return nil, ErrDiscoveryAndLocal
+	}
+
+	version, plugins, err := c.checkProtoVersion(strconv.Itoa(d.ProtocolVersion))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.StartTimeout)
+	defer cancel()
+	addr, err := d.Resolve(ctx, d.ServiceName)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving plugin service %q: %w", d.ServiceName, err)
+	}
+
+	c.doneCtx, c.ctxCancel = context.WithCancel(context.Background())
+
+	c.address = addr
+	c.startIdleTimer()
+	c.negotiatedVersion = version
+	c.negotiatedPlugins = plugins
+
+	return c.address, nil
+}
+
+// resolveAddr returns the address to dial the plugin at. Plugins found
+// through ClientConfig.Discovery are resolved again on every dial.
+func (c *Client) resolveAddr(timeout time.Duration) (net.Addr, error) {
+	d := c.config.Discovery
+	if d == nil {
+		return c.address, nil
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	addr, err := d.Resolve(ctx, d.ServiceName)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving plugin service %q: %w", d.ServiceName, err)
+	}
+	c.logger.Debug("resolved plugin service", "service", d.ServiceName, "address", addr)
+	return addr, nil
+}
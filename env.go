@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// EnvMergePolicy controls how the environment set on ClientConfig.Cmd is
+// merged with the host environment and the variables the client sets for
+// the plugin, such as the magic cookie.
+type EnvMergePolicy int
+
+const (
+	// EnvMergeAppend, the default, appends the host environment (unless
+	// SkipHostEnv is set) and the plugin variables to Cmd.Env. When a
+	// variable is set more than once, the last value wins: plugin variables
+	// override the host environment, which overrides Cmd.Env.
+	EnvMergeAppend EnvMergePolicy = iota
+
+	// EnvMergeReplace uses Cmd.Env, if it is not nil, instead of the host
+	// environment, so the plugin gets a clean environment. The plugin
+	// variables are still added and override Cmd.Env.
+	EnvMergeReplace
+
+	// EnvMergeErrorOnConflict is like EnvMergeAppend, except that Start fails
+	// with ErrEnvConflict if Cmd.Env sets a variable the client sets for the
+	// plugin.
+	EnvMergeErrorOnConflict
+)
+
+// ErrEnvConflict is returned by Start when ClientConfig.EnvMergePolicy is
+// EnvMergeErrorOnConflict and Cmd.Env sets a variable the client sets too.
+var ErrEnvConflict = errors.New("Cmd.Env conflicts with the plugin environment")
+
+// mergeEnv merges the command, host and plugin environments according to
+// policy. The result holds each variable once, with the value that wins.
+func mergeEnv(policy EnvMergePolicy, cmdEnv, hostEnv, pluginEnv []string) ([]string, error) {
+	switch policy {
+	case EnvMergeAppend:
+	case EnvMergeReplace:
+		if cmdEnv != nil {
+			hostEnv = nil
+		}
+	case EnvMergeErrorOnConflict:
+		set := make(map[string]bool, len(pluginEnv))
+		for _, kv := range pluginEnv {
+			set[envKey(kv)] = true
+		}
+		var conflicts []string
+		for _, kv := range cmdEnv {
+			if k := envKey(kv); set[k] {
+				conflicts = append(conflicts, k)
+			}
+		}
+		if len(conflicts) > 0 {
+			return nil, fmt.Errorf("%w: %s", ErrEnvConflict, strings.Join(conflicts, ", "))
+		}
+	default:
+		return nil, fmt.Errorf("unknown EnvMergePolicy %d", policy)
+	}
+
+	return dedupEnv(append(append(append([]string(nil), cmdEnv...), hostEnv...), pluginEnv...)), nil
+}
+
+// dedupEnv returns env with each variable only once, keeping the last value
+// at the position it was first set at.
+func dedupEnv(env []string) []string {
+	index := make(map[string]int, len(env))
+	out := make([]string, 0, len(env))
+	for _, kv := range env {
+		k := envKey(kv)
+		if i, ok := index[k]; ok {
+			out[i] = kv
+			continue
+		}
+		index[k] = len(out)
+		out = append(out, kv)
+	}
+	return out
+}
+
+// envKey returns the name of a KEY=value environment entry.
+func envKey(kv string) string {
+	k, _, _ := strings.Cut(kv, "=")
+	return k
+}
@@ -0,0 +1,49 @@
+package plugin
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMergeEnv(t *testing.T) {
+	cmdEnv := []string{"A=cmd", "B=cmd", "COOKIE=cmd"}
+	hostEnv := []string{"B=host", "C=host"}
+	pluginEnv := []string{"COOKIE=plugin"}
+
+	cases := []struct {
+		name   string
+		policy EnvMergePolicy
+		cmdEnv []string
+		want   []string
+		err    error
+	}{
+		{"append", EnvMergeAppend, cmdEnv, []string{"A=cmd", "B=host", "COOKIE=plugin", "C=host"}, nil},
+		{"replace", EnvMergeReplace, cmdEnv, []string{"A=cmd", "B=cmd", "COOKIE=plugin"}, nil},
+		{"replace without cmd env", EnvMergeReplace, nil, []string{"B=host", "C=host", "COOKIE=plugin"}, nil},
+		{"conflict", EnvMergeErrorOnConflict, cmdEnv, nil, ErrEnvConflict},
+		{"no conflict", EnvMergeErrorOnConflict, []string{"A=cmd"}, []string{"A=cmd", "B=host", "C=host", "COOKIE=plugin"}, nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := mergeEnv(tc.policy, tc.cmdEnv, hostEnv, pluginEnv)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("err %v, want %v", err, tc.err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("env %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClientStart_envConflict(t *testing.T) {
+	config := testClientConfig("test-grpc", testHandshake.MagicCookieKey+"=other")
+	config.EnvMergePolicy = EnvMergeErrorOnConflict
+	c := NewClient(config)
+	defer c.Kill()
+
+	if _, err := c.Start(); !errors.Is(err, ErrEnvConflict) {
+		t.Fatalf("expected ErrEnvConflict, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+
+	"github.com/kform-dev/plugin/runner"
+)
+
+// newEventLogger returns the logger writing the NDJSON event stream of
+// ClientConfig.EventWriter for the plugin run by r, or nil if no EventWriter
+// is configured. Every record carries the plugin name and pid.
+func (c *Client) newEventLogger(r runner.Runner) *slog.Logger {
+	if c.config.EventWriter == nil {
+		return nil
+	}
+
+	var pid any = r.ID()
+	if n, err := strconv.Atoi(r.ID()); err == nil {
+		pid = n
+	}
+	return slog.New(slog.NewJSONHandler(c.config.EventWriter, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	})).With("plugin", filepath.Base(r.Name()), "pid", pid)
+}
+
+// event records a plugin lifecycle event in the event stream, if any.
+func (c *Client) event(level slog.Level, msg string, args ...any) {
+	if c.events == nil {
+		return
+	}
+	c.events.Log(context.Background(), level, msg, append([]any{"event", "lifecycle"}, args...)...)
+}
+
+// withEvents returns a logger that logs to l and, if an event stream is
+// configured, to the event stream as plugin log records.
+func (c *Client) withEvents(l *slog.Logger) *slog.Logger {
+	if c.events == nil {
+		return l
+	}
+	return slog.New(teeHandler{l.Handler(), c.events.With("event", "log").Handler()})
+}
+
+// teeHandler is a slog.Handler that hands records to several handlers.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	hs := make(teeHandler, len(t))
+	for i, h := range t {
+		hs[i] = h.WithAttrs(attrs)
+	}
+	return hs
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	hs := make(teeHandler, len(t))
+	for i, h := range t {
+		hs[i] = h.WithGroup(name)
+	}
+	return hs
+}
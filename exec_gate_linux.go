@@ -0,0 +1,74 @@
+//go:build linux
+// +build linux
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"syscall"
+)
+
+// gateExec makes the plugin stop right after it is exec'd, before running any
+// of its own code, until releaseExec lets it continue. The process is traced
+// by the thread starting it, which must be locked with runtime.LockOSThread
+// until releaseExec returns.
+func gateExec(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Ptrace = true
+	return nil
+}
+
+// releaseExec waits for the plugin gated by gateExec to stop at exec and for
+// the checksum verification to finish. The plugin is let go if the checksum
+// matched, and killed otherwise.
+func releaseExec(ctx context.Context, cmd *exec.Cmd, verified <-chan error) error {
+	if cmd.Process == nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("concurrent checksum verification requires the plugin to be started from ClientConfig.Cmd")
+	}
+	defer runtime.UnlockOSThread()
+
+	pid := cmd.Process.Pid
+
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &ws, 0, nil); err != nil {
+		return killGated(cmd, fmt.Errorf("waiting for plugin to stop at exec: %w", err))
+	}
+	if !ws.Stopped() {
+		// The plugin died before reaching its first instruction, and was
+		// reaped by Wait4, so its pid may already be reused: release it
+		// instead of killing it, and report the status from here.
+		cmd.Process.Release()
+		cmd.Wait()
+		if ws.Signaled() {
+			return fmt.Errorf("plugin was killed by %s before its checksum was verified", ws.Signal())
+		}
+		return fmt.Errorf("plugin exited with status %d before its checksum was verified", ws.ExitStatus())
+	}
+
+	select {
+	case err := <-verified:
+		if err != nil {
+			return killGated(cmd, err)
+		}
+	case <-ctx.Done():
+		return killGated(cmd, fmt.Errorf("timeout while verifying checksum: %w", ctx.Err()))
+	}
+
+	if err := syscall.PtraceDetach(pid); err != nil {
+		return killGated(cmd, fmt.Errorf("releasing plugin: %w", err))
+	}
+	return nil
+}
+
+// killGated kills and reaps a plugin stopped by gateExec.
+func killGated(cmd *exec.Cmd, err error) error {
+	cmd.Process.Kill()
+	cmd.Wait()
+	return err
+}
@@ -0,0 +1,27 @@
+//go:build linux
+// +build linux
+
+package plugin
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestReleaseExec_exited(t *testing.T) {
+	// The plugin isn't gated, so releaseExec sees it exit instead of
+	// stopping at exec.
+	runtime.LockOSThread()
+	cmd := helperProcess("exit")
+	if err := cmd.Start(); err != nil {
+		runtime.UnlockOSThread()
+		t.Fatalf("err: %s", err)
+	}
+
+	err := releaseExec(context.Background(), cmd, make(chan error))
+	if err == nil || !strings.Contains(err.Error(), "exited with status 3") {
+		t.Fatalf("expected the exit status of the plugin, got %v", err)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package plugin
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+)
+
+func gateExec(_ *exec.Cmd) error {
+	return ErrConcurrentCheckUnsupported
+}
+
+func releaseExec(_ context.Context, _ *exec.Cmd, _ <-chan error) error {
+	runtime.UnlockOSThread()
+	return ErrConcurrentCheckUnsupported
+}
@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// ErrFDCountUnsupported is returned by Client.FDCount on platforms where the
+// open file descriptors of a process can't be counted.
+var ErrFDCountUnsupported = errors.New("counting file descriptors is not supported on this platform")
+
+// fdCheckInterval is how often the open file descriptors of the plugin are
+// counted when ClientConfig.FDWarnThreshold is set.
+const fdCheckInterval = 10 * time.Second
+
+// FDCount returns the number of file descriptors the plugin process has
+// open. It is only supported on Linux.
+func (c *Client) FDCount() (int, error) {
+	c.m.Lock()
+	r := c.runner
+	exited := c.exited
+	c.m.Unlock()
+
+	if r == nil || exited {
+		return 0, errors.New("plugin is not running")
+	}
+	pid, err := strconv.Atoi(r.ID())
+	if err != nil {
+		return 0, fmt.Errorf("plugin id %q is not a pid", r.ID())
+	}
+	return countFDs(pid)
+}
+
+// startFDWatch periodically counts the open file descriptors of the plugin
+// until ctx is done, if a ClientConfig.FDWarnThreshold is configured, and
+// warns once the count exceeds it. The caller must hold c.m.
+func (c *Client) startFDWatch(ctx context.Context) {
+	threshold := c.config.FDWarnThreshold
+	if threshold <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(fdCheckInterval)
+		defer ticker.Stop()
+
+		// Warn once per excursion above the threshold.
+		above := false
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			n, err := c.FDCount()
+			if err != nil {
+				c.logger.Debug("cannot count plugin file descriptors", "error", err)
+				return
+			}
+			switch {
+			case n > threshold && !above:
+				above = true
+				c.logger.Warn("plugin has many open file descriptors", "count", n, "threshold", threshold)
+				c.event(slog.LevelWarn, "plugin file descriptors above threshold", "count", n, "threshold", threshold)
+			case n <= threshold:
+				above = false
+			}
+		}
+	}()
+}
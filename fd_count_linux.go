@@ -0,0 +1,17 @@
+//go:build linux
+// +build linux
+
+package plugin
+
+import (
+	"os"
+	"strconv"
+)
+
+func countFDs(pid int) (int, error) {
+	entries, err := os.ReadDir("/proc/" + strconv.Itoa(pid) + "/fd")
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package plugin
+
+func countFDs(int) (int, error) {
+	return 0, ErrFDCountUnsupported
+}
@@ -0,0 +1,25 @@
+// Package forkserver runs plugins by asking a warm "fork server" process to
+// fork them, instead of starting each plugin from scratch. It is meant for
+// plugins backed by an interpreter, such as Python or Node.js, where starting
+// the interpreter and loading the plugin's code dominates the startup time:
+// the fork server does that once, and every plugin is a fork of it.
+//
+// The fork server is a shim written for the interpreter. It is started by
+// Start and receives spawn requests on a Unix datagram socket whose file
+// descriptor number is set in the FORKSERVER_FD environment variable. Each
+// request is one datagram holding a JSON object:
+//
+//	{"path": "...", "args": ["..."], "env": ["KEY=value"], "dir": "..."}
+//
+// with three file descriptors attached as SCM_RIGHTS ancillary data: the
+// write ends of the plugin's stdout and stderr pipes, and a Unix stream
+// socket to report on. The shim forks, makes the two pipes the child's
+// stdout and stderr, replaces the child's environment with env, changes to
+// dir if set, and runs the plugin described by path and args, which then
+// serves as any other plugin and prints its handshake to stdout. The shim
+// writes newline terminated JSON objects to the report socket: first
+// {"pid": N} once the child was forked, or {"error": "..."} if it could not
+// be, then {"exit_status": N} once it reaped the child.
+//
+// The fork server is not supported on Windows.
+package forkserver
@@ -0,0 +1,154 @@
+//go:build !windows
+// +build !windows
+
+package forkserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/kform-dev/plugin/runner"
+)
+
+// EnvFD is the environment variable holding the file descriptor number of
+// the socket the fork server receives spawn requests on.
+const EnvFD = "FORKSERVER_FD"
+
+// ErrClosed is returned when spawning a plugin from a closed Server.
+var ErrClosed = errors.New("fork server closed")
+
+// Server is a running fork server.
+type Server struct {
+	logger *slog.Logger
+	cmd    *exec.Cmd
+
+	m      sync.Mutex
+	ctrl   *net.UnixConn
+	closed bool
+}
+
+// spawnRequest is the request sent to the fork server to fork a plugin.
+type spawnRequest struct {
+	Path string   `json:"path"`
+	Args []string `json:"args"`
+	Env  []string `json:"env"`
+	Dir  string   `json:"dir,omitempty"`
+}
+
+// report is a message written by the fork server on the report socket of a
+// plugin.
+type report struct {
+	Pid        int    `json:"pid,omitempty"`
+	Error      string `json:"error,omitempty"`
+	ExitStatus *int   `json:"exit_status,omitempty"`
+}
+
+// Start starts the fork server shim, which must not have been started yet.
+// The shim's stdout and stderr are left as configured on shim.
+func Start(logger *slog.Logger, shim *exec.Cmd) (*Server, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil, fmt.Errorf("creating fork server socket: %w", err)
+	}
+	syscall.CloseOnExec(fds[0])
+	hostEnd := os.NewFile(uintptr(fds[0]), "forkserver")
+	shimEnd := os.NewFile(uintptr(fds[1]), "forkserver-shim")
+	defer hostEnd.Close()
+	defer shimEnd.Close()
+
+	// ExtraFiles entry i is file descriptor 3+i in the shim.
+	shim.ExtraFiles = append(shim.ExtraFiles, shimEnd)
+	if shim.Env == nil {
+		shim.Env = os.Environ()
+	}
+	shim.Env = append(shim.Env, fmt.Sprintf("%s=%d", EnvFD, 2+len(shim.ExtraFiles)))
+
+	conn, err := net.FileConn(hostEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := shim.Start(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	logger.Debug("fork server started", "path", shim.Path, "pid", shim.Process.Pid)
+
+	return &Server{
+		logger: logger,
+		cmd:    shim,
+		ctrl:   conn.(*net.UnixConn),
+	}, nil
+}
+
+// RunnerFunc can be used as ClientConfig.RunnerFunc to run plugins forked
+// by the fork server. The Path, Args, Env and Dir of the command are sent to
+// the fork server, the rest of it is ignored.
+func (s *Server) RunnerFunc(logger *slog.Logger, cmd *exec.Cmd, _ string) (runner.Runner, error) {
+	return &Runner{
+		server: s,
+		logger: logger,
+		cmd:    cmd,
+	}, nil
+}
+
+// Close stops the fork server. Plugins it already forked keep running.
+func (s *Server) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	s.ctrl.Close()
+	if err := s.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	s.cmd.Wait()
+	return nil
+}
+
+// spawn asks the fork server to fork a plugin writing its output to stdout
+// and stderr, which it reports on through report.
+func (s *Server) spawn(cmd *exec.Cmd, stdout, stderr, report *os.File) error {
+	b, err := json.Marshal(spawnRequest{
+		Path: cmd.Path,
+		Args: cmd.Args,
+		Env:  cmd.Env,
+		Dir:  cmd.Dir,
+	})
+	if err != nil {
+		return err
+	}
+	oob := syscall.UnixRights(int(stdout.Fd()), int(stderr.Fd()), int(report.Fd()))
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	if _, _, err := s.ctrl.WriteMsgUnix(b, oob, nil); err != nil {
+		return fmt.Errorf("sending spawn request to fork server: %w", err)
+	}
+	return nil
+}
+
+// alive reports whether the fork server is still running.
+func (s *Server) alive() bool {
+	return s.cmd.Process.Signal(syscall.Signal(0)) == nil
+}
+
+func (s *Server) String() string {
+	return s.cmd.Path + " (pid " + strconv.Itoa(s.cmd.Process.Pid) + ")"
+}
@@ -0,0 +1,201 @@
+//go:build !windows
+// +build !windows
+
+package forkserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/kform-dev/plugin/runner"
+)
+
+var (
+	_ runner.Runner   = (*Runner)(nil)
+	_ runner.Signaler = (*Runner)(nil)
+)
+
+// Runner is a runner.Runner for a plugin forked by a Server. It is created
+// by Server.RunnerFunc.
+type Runner struct {
+	server *Server
+	logger *slog.Logger
+	cmd    *exec.Cmd
+
+	stdout io.ReadCloser
+	stderr io.ReadCloser
+
+	conn    net.Conn
+	reports *bufio.Reader
+	pid     int
+}
+
+func (r *Runner) Start(ctx context.Context) (err error) {
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		stdoutR.Close()
+		stdoutW.Close()
+		return err
+	}
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		stdoutR.Close()
+		stdoutW.Close()
+		stderrR.Close()
+		stderrW.Close()
+		return err
+	}
+	syscall.CloseOnExec(fds[0])
+	hostEnd := os.NewFile(uintptr(fds[0]), "forkserver-report")
+	shimEnd := os.NewFile(uintptr(fds[1]), "forkserver-report-shim")
+
+	defer func() {
+		// The fork server has its own copies of these now.
+		stdoutW.Close()
+		stderrW.Close()
+		shimEnd.Close()
+		hostEnd.Close()
+		if err != nil {
+			stdoutR.Close()
+			stderrR.Close()
+		}
+	}()
+
+	conn, err := net.FileConn(hostEnd)
+	if err != nil {
+		return err
+	}
+
+	r.logger.Debug("forking plugin", "path", r.cmd.Path, "args", r.cmd.Args, "server", r.server.String())
+	if err := r.server.spawn(r.cmd, stdoutW, stderrW, shimEnd); err != nil {
+		conn.Close()
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+	reports := bufio.NewReader(conn)
+	rep, err := readReport(reports)
+	conn.SetReadDeadline(time.Time{})
+	switch {
+	case err != nil:
+		err = fmt.Errorf("waiting for fork server: %w", err)
+	case rep.Error != "":
+		err = fmt.Errorf("fork server could not fork plugin: %s", rep.Error)
+	case rep.Pid <= 0:
+		err = errors.New("fork server did not report the pid of the plugin")
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	r.stdout = stdoutR
+	r.stderr = stderrR
+	r.conn = conn
+	r.reports = reports
+	r.pid = rep.Pid
+	r.logger.Debug("plugin forked", "path", r.cmd.Path, "pid", r.pid)
+	return nil
+}
+
+// readReport reads the next report of the fork server.
+func readReport(r *bufio.Reader) (*report, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	rep := &report{}
+	if err := json.Unmarshal(line, rep); err != nil {
+		return nil, fmt.Errorf("invalid fork server report %q: %w", line, err)
+	}
+	return rep, nil
+}
+
+func (r *Runner) Wait(_ context.Context) error {
+	defer r.conn.Close()
+
+	for {
+		rep, err := readReport(r.reports)
+		if err != nil {
+			return fmt.Errorf("lost track of plugin %d: %w", r.pid, err)
+		}
+		if rep.ExitStatus != nil {
+			if *rep.ExitStatus != 0 {
+				return fmt.Errorf("exit status %d", *rep.ExitStatus)
+			}
+			return nil
+		}
+	}
+}
+
+func (r *Runner) Kill(_ context.Context) error {
+	if r.pid <= 0 {
+		return nil
+	}
+	err := syscall.Kill(r.pid, syscall.SIGKILL)
+	// Swallow ESRCH, we support calling Kill multiple times.
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+func (r *Runner) Signal(sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("unsupported signal %v", sig)
+	}
+	if r.pid <= 0 {
+		return nil
+	}
+	err := syscall.Kill(r.pid, s)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+func (r *Runner) Stdout() io.ReadCloser { return r.stdout }
+
+func (r *Runner) Stderr() io.ReadCloser { return r.stderr }
+
+func (r *Runner) Name() string { return r.cmd.Path }
+
+func (r *Runner) ID() string {
+	if r.pid <= 0 {
+		return ""
+	}
+	return strconv.Itoa(r.pid)
+}
+
+func (r *Runner) Diagnose(_ context.Context) string {
+	if !r.server.alive() {
+		return fmt.Sprintf("The fork server %s is no longer running.", r.server)
+	}
+	return fmt.Sprintf("The plugin was forked by the fork server %s, check its output for errors.", r.server)
+}
+
+func (r *Runner) PluginToHost(pluginNet, pluginAddr string) (string, string, error) {
+	return pluginNet, pluginAddr, nil
+}
+
+func (r *Runner) HostToPlugin(hostNet, hostAddr string) (string, string, error) {
+	return hostNet, hostAddr, nil
+}
@@ -68,6 +68,19 @@ func newGRPCBroker(s streamer, tls *tls.Config, unixSocketCfg UnixSocketConfig,
 	}
 }
 
+// validateBrokerTLS checks that a dedicated broker TLS configuration can be
+// used on both ends of a brokered connection. Each side of the broker may
+// accept connections, so the configuration must provide a certificate.
+func validateBrokerTLS(cfg *tls.Config) error {
+	if cfg == nil {
+		return nil
+	}
+	if len(cfg.Certificates) == 0 && cfg.GetCertificate == nil && cfg.GetConfigForClient == nil {
+		return fmt.Errorf("broker tls config has no certificates")
+	}
+	return nil
+}
+
 // Accept accepts a connection by ID.
 //
 // This should not be called multiple times with the same ID at one time.
@@ -3,6 +3,8 @@ package plugin
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"net"
@@ -10,6 +12,7 @@ import (
 
 	"github.com/kform-dev/plugin/internal/plugin"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/health/grpc_health_v1"
 )
@@ -50,17 +53,131 @@ func dialGRPCConn(tls *tls.Config, dialer func(string, time.Duration) (net.Conn,
 	return conn, nil
 }
 
+// waitConnReady waits up to timeout for conn to be connected, including the
+// TLS handshake, since dialing the connection doesn't wait for it.
+func waitConnReady(conn *grpc.ClientConn, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn.Connect()
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("timeout establishing gRPC connection to plugin after %s, connection is %s", timeout, state)
+		}
+	}
+}
+
+// validateServiceConfig checks that js is a valid gRPC service config.
+func validateServiceConfig(js string) error {
+	if !json.Valid([]byte(js)) {
+		return errors.New("invalid ServiceConfigJSON: not valid JSON")
+	}
+
+	// NewClient parses the service config without connecting.
+	conn, err := grpc.NewClient("passthrough:///unused", grpc.WithInsecure(), grpc.WithDefaultServiceConfig(js))
+	if err != nil {
+		return fmt.Errorf("invalid ServiceConfigJSON: %w", err)
+	}
+	conn.Close()
+	return nil
+}
+
 // newGRPCClient creates a new GRPCClient. The Client argument is expected
 // to be successfully started already with a lock held.
 func newGRPCClient(doneCtx context.Context, c *Client) (*GRPCClient, error) {
-	conn, err := dialGRPCConn(c.config.TLSConfig, c.dialer, c.config.GRPCDialOptions...)
+	return dialGRPCClient(doneCtx, c, c.dialer, c.negotiatedPlugins)
+}
+
+// dialGRPCClient creates a new GRPCClient connected with dialer that
+// dispenses the given plugins.
+func dialGRPCClient(doneCtx context.Context, c *Client, dialer func(string, time.Duration) (net.Conn, error), plugins PluginSet) (*GRPCClient, error) {
+	// The interceptors of the config are outermost, so they see the calls
+	// as they are made by the host.
+	var dialOpts []grpc.DialOption
+	if len(c.config.GRPCUnaryInterceptors) > 0 {
+		dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(c.config.GRPCUnaryInterceptors...))
+	}
+	if len(c.config.GRPCStreamInterceptors) > 0 {
+		dialOpts = append(dialOpts, grpc.WithChainStreamInterceptor(c.config.GRPCStreamInterceptors...))
+	}
+	dialOpts = append(dialOpts,
+		grpc.WithChainUnaryInterceptor(cancelReasonUnaryInterceptor),
+		grpc.WithChainStreamInterceptor(cancelReasonStreamInterceptor))
+	if c.idle != nil {
+		dialOpts = append(dialOpts,
+			grpc.WithChainUnaryInterceptor(c.idle.unaryInterceptor),
+			grpc.WithChainStreamInterceptor(c.idle.streamInterceptor))
+	}
+	if c.config.OnBackpressure != nil {
+		bp := newBackpressureTracker(doneCtx, c.config.OnBackpressure)
+		dialOpts = append(dialOpts,
+			grpc.WithChainUnaryInterceptor(bp.unaryInterceptor),
+			grpc.WithChainStreamInterceptor(bp.streamInterceptor))
+	}
+	serviceConfig := c.config.ServiceConfigJSON
+	if c.config.RetryBudget != nil {
+		var err error
+		if serviceConfig, err = serviceConfigWithRetryBudget(serviceConfig, c.config.RetryBudget); err != nil {
+			return nil, err
+		}
+	}
+	if serviceConfig != "" {
+		if err := validateServiceConfig(serviceConfig); err != nil {
+			return nil, err
+		}
+		dialOpts = append(dialOpts, grpc.WithDefaultServiceConfig(serviceConfig))
+	}
+	if c.config.GRPCMaxRecvMsgSize > 0 {
+		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(c.config.GRPCMaxRecvMsgSize)))
+	}
+	if c.config.GRPCMaxSendMsgSize > 0 {
+		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(grpc.MaxCallSendMsgSize(c.config.GRPCMaxSendMsgSize)))
+	}
+	if c.config.GRPCKeepaliveParams != nil {
+		dialOpts = append(dialOpts, grpc.WithKeepaliveParams(*c.config.GRPCKeepaliveParams))
+	}
+	if c.config.CompressThreshold > 0 {
+		dialOpts = append(dialOpts, compressDialOption(c.config.CompressThreshold))
+	}
+	dialOpts = append(dialOpts, c.config.GRPCDialOptions...)
+	if c.config.MessageMAC {
+		// Innermost, so the MAC covers the messages as they are sent.
+		dialOpts = append(dialOpts, grpc.WithChainUnaryInterceptor(macUnaryClientInterceptor(deriveMACKey(c.config.HandshakeConfig))))
+	}
+
+	tlsConfig := c.config.TLSConfig
+	brokerTLS := c.config.BrokerTLSConfig
+	if brokerTLS == nil {
+		brokerTLS = tlsConfig
+	}
+	if c.config.ExpectedSPIFFEID != "" {
+		var err error
+		if tlsConfig, err = withSPIFFEVerification(tlsConfig, c.config.ExpectedSPIFFEID); err != nil {
+			return nil, err
+		}
+		if brokerTLS, err = withSPIFFEVerification(brokerTLS, c.config.ExpectedSPIFFEID); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := dialGRPCConn(tlsConfig, dialer, dialOpts...)
 	if err != nil {
 		return nil, err
 	}
+	if c.config.GRPCDialTimeout > 0 {
+		if err := waitConnReady(conn, c.config.GRPCDialTimeout); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
 
 	// Start the broker.
 	brokerGRPCClient := newGRPCBrokerClient(conn)
-	broker := newGRPCBroker(brokerGRPCClient, c.config.TLSConfig, c.unixSocketCfg, c.runner)
+	broker := newGRPCBroker(brokerGRPCClient, brokerTLS, c.unixSocketCfg, c.runner)
 	go broker.Run()
 	go brokerGRPCClient.StartStream()
 
@@ -69,11 +186,16 @@ func newGRPCClient(doneCtx context.Context, c *Client) (*GRPCClient, error) {
 	if err != nil {
 		return nil, err
 	}
-	go stdioClient.Run(c.config.SyncStdout, c.config.SyncStderr)
+	// Kill waits for the forwarded output to be written before returning.
+	c.clientWaitGroup.Add(1)
+	go func() {
+		defer c.clientWaitGroup.Done()
+		stdioClient.Run(c.config.SyncStdout, c.config.SyncStderr)
+	}()
 
 	cl := &GRPCClient{
 		Conn:       conn,
-		Plugins:    c.negotiatedPlugins,
+		Plugins:    plugins,
 		doneCtx:    doneCtx,
 		broker:     broker,
 		controller: plugin.NewGRPCControllerClient(conn),
@@ -100,6 +222,13 @@ func (c *GRPCClient) Close() error {
 	return c.Conn.Close()
 }
 
+// disconnect closes the broker and the connection without asking the plugin
+// to shut down.
+func (c *GRPCClient) disconnect() error {
+	c.broker.Close()
+	return c.Conn.Close()
+}
+
 // ClientProtocol impl.
 func (c *GRPCClient) Dispense(name string) (interface{}, error) {
 	p, ok := c.Plugins[name]
@@ -4,6 +4,8 @@ import (
 	"context"
 
 	"github.com/kform-dev/plugin/internal/plugin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // GRPCControllerServer handles shutdown calls to terminate the server when the
@@ -22,3 +24,35 @@ func (s *grpcControllerServer) Shutdown(ctx context.Context, _ *plugin.Empty) (*
 	s.server.Stop()
 	return resp, nil
 }
+
+// Cancel cancels an in-flight call with the reason given by the host.
+func (s *grpcControllerServer) Cancel(ctx context.Context, req *plugin.CancelRequest) (*plugin.Empty, error) {
+	s.server.cancels.cancel(req.CallId, CancelReason(req.Reason))
+	return &plugin.Empty{}, nil
+}
+
+// DebugDump returns the state reported by the DebugDumper of the server.
+func (s *grpcControllerServer) DebugDump(ctx context.Context, _ *plugin.Empty) (*plugin.DebugDumpResponse, error) {
+	if s.server.DebugDumper == nil {
+		return nil, status.Error(codes.Unimplemented, "plugin does not support debug dumps")
+	}
+
+	values, err := s.server.DebugDumper.DebugDump(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &plugin.DebugDumpResponse{Values: values}, nil
+}
+
+// HealthDetails returns the report of the HealthReporter of the server.
+func (s *grpcControllerServer) HealthDetails(ctx context.Context, _ *plugin.Empty) (*plugin.HealthDetailsResponse, error) {
+	if s.server.HealthReporter == nil {
+		return nil, status.Error(codes.Unimplemented, "plugin does not support health details")
+	}
+
+	report, err := s.server.HealthReporter.HealthReport(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return healthDetailsResponse(report), nil
+}
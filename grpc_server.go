@@ -12,8 +12,8 @@ import (
 	"github.com/kform-dev/plugin/internal/plugin"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/reflection"
 )
 
@@ -21,6 +21,12 @@ import (
 // return as passing.
 const GRPCServiceName = "plugin"
 
+// DefaultGRPCServer can be used with the "GRPCServer" field for Server
+// as a default factory method to create a gRPC server.
+func DefaultGRPCServer(opts []grpc.ServerOption) *grpc.Server {
+	return grpc.NewServer(opts...)
+}
+
 // GRPCServer is a ServerType implementation that serves plugins over
 // gRPC. This allows plugins to easily be written for other languages.
 //
@@ -31,13 +37,59 @@ type GRPCServer struct {
 	Plugins map[string]Plugin
 
 	// Server is the actual server that will accept connections. This
-	// will be used for plugin registration as well.
+	// will be used for plugin registration as well. It is called with the
+	// options the plugin needs, which it must pass on to grpc.NewServer,
+	// see ServeConfig.GRPCServer.
 	Server func([]grpc.ServerOption) *grpc.Server
 
 	// TLS should be the TLS configuration if available. If this is nil,
 	// the connection will not have transport security.
 	TLS *tls.Config
 
+	// BrokerTLS is the TLS configuration for connections brokered through
+	// the GRPCBroker. If this is nil, TLS is used.
+	BrokerTLS *tls.Config
+
+	// MACKey, if set, is the key used to verify the MAC of every unary
+	// request and to MAC every unary response.
+	MACKey []byte
+
+	// DebugDumper, if set, reports the state returned to Client.DebugDump.
+	DebugDumper DebugDumper
+
+	// HealthReporter, if set, reports the health returned to
+	// Client.HealthDetails.
+	HealthReporter HealthReporter
+
+	// HealthServer, if set, is the health service of the plugin, through
+	// which it reports whether it is serving. If nil, the plugin always
+	// reports SERVING.
+	HealthServer *HealthServer
+
+	// UnaryInterceptors and StreamInterceptors are chained, in order, on
+	// the server, e.g. for panic recovery or request logging. They run
+	// before the interceptors of the server itself.
+	UnaryInterceptors  []grpc.UnaryServerInterceptor
+	StreamInterceptors []grpc.StreamServerInterceptor
+
+	// CompressThreshold, if positive, is the size in bytes above which
+	// responses are compressed, see ClientConfig.CompressThreshold.
+	CompressThreshold int
+
+	// KeepaliveParams and KeepaliveEnforcementPolicy, if set, configure the
+	// keepalive of the server: how it pings idle connections and prunes
+	// dead ones, and how often clients may ping it. The policy must allow
+	// the pings of ClientConfig.GRPCKeepaliveParams, or the server closes
+	// the connection with ENHANCE_YOUR_CALM.
+	KeepaliveParams            *keepalive.ServerParameters
+	KeepaliveEnforcementPolicy *keepalive.EnforcementPolicy
+
+	// MaxRecvMsgSize and MaxSendMsgSize, if set, are the largest messages
+	// in bytes the server receives and sends. They default to the gRPC
+	// defaults of 4MB and 2GB.
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+
 	// DoneCh is the channel that is closed when this server has exited.
 	DoneCh chan struct{}
 
@@ -50,6 +102,11 @@ type GRPCServer struct {
 	server      *grpc.Server
 	broker      *GRPCBroker
 	stdioServer *grpcStdioServer
+	cancels     *cancelRegistry
+
+	// serveErr is the error Serve returned with, set before DoneCh is
+	// closed.
+	serveErr error
 
 	logger *slog.Logger
 }
@@ -61,13 +118,51 @@ func (s *GRPCServer) Init() error {
 	if s.TLS != nil {
 		opts = append(opts, grpc.Creds(credentials.NewTLS(s.TLS)))
 	}
+
+	if s.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(s.MaxRecvMsgSize))
+	}
+	if s.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(s.MaxSendMsgSize))
+	}
+	if s.KeepaliveParams != nil {
+		opts = append(opts, grpc.KeepaliveParams(*s.KeepaliveParams))
+	}
+	if s.KeepaliveEnforcementPolicy != nil {
+		opts = append(opts, grpc.KeepaliveEnforcementPolicy(*s.KeepaliveEnforcementPolicy))
+	}
+	if s.CompressThreshold > 0 {
+		opts = append(opts, compressServerOptions(s.CompressThreshold)...)
+	}
+
+	// The interceptors of the plugin are outermost, so they see the calls
+	// as they are made by the host.
+	if len(s.UnaryInterceptors) > 0 {
+		opts = append(opts, grpc.ChainUnaryInterceptor(s.UnaryInterceptors...))
+	}
+	if len(s.StreamInterceptors) > 0 {
+		opts = append(opts, grpc.ChainStreamInterceptor(s.StreamInterceptors...))
+	}
+
+	// Track calls so the host can cancel them with a reason.
+	s.cancels = newCancelRegistry()
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(s.cancels.unaryInterceptor),
+		grpc.ChainStreamInterceptor(s.cancels.streamInterceptor),
+		grpc.ChainUnaryInterceptor(backpressureUnaryServerInterceptor),
+		grpc.ChainStreamInterceptor(backpressureStreamServerInterceptor))
+	if s.MACKey != nil {
+		opts = append(opts, grpc.ChainUnaryInterceptor(macUnaryServerInterceptor(s.MACKey)))
+	}
+
 	s.server = s.Server(opts)
 
 	// Register the health service
-	healthCheck := health.NewServer()
-	healthCheck.SetServingStatus(
-		GRPCServiceName, grpc_health_v1.HealthCheckResponse_SERVING)
-	grpc_health_v1.RegisterHealthServer(s.server, healthCheck)
+	healthServer := s.HealthServer
+	if healthServer == nil {
+		healthServer = NewHealthServer(true)
+	}
+	grpc_health_v1.RegisterHealthServer(s.server, healthServer.server)
 
 	// Register the reflection service
 	reflection.Register(s.server)
@@ -75,7 +170,11 @@ func (s *GRPCServer) Init() error {
 	// Register the broker service
 	brokerServer := newGRPCBrokerServer()
 	plugin.RegisterGRPCBrokerServer(s.server, brokerServer)
-	s.broker = newGRPCBroker(brokerServer, s.TLS, unixSocketConfigFromEnv(), nil)
+	brokerTLS := s.BrokerTLS
+	if brokerTLS == nil {
+		brokerTLS = s.TLS
+	}
+	s.broker = newGRPCBroker(brokerServer, brokerTLS, unixSocketConfigFromEnv(), nil)
 	go s.broker.Run()
 
 	// Register the controller
@@ -140,6 +239,18 @@ func (s *GRPCServer) Serve(lis net.Listener) {
 	if err != nil {
 		s.logger.Error("grpc server", "error", err)
 	}
+	s.serveErr = err
+}
+
+// Err returns the error Serve stopped with, or nil if it stopped cleanly. It
+// returns nil until DoneCh is closed.
+func (s *GRPCServer) Err() error {
+	select {
+	case <-s.DoneCh:
+		return s.serveErr
+	default:
+		return nil
+	}
 }
 
 // GRPCServerConfig is the extra configuration passed along for consumers
@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/stats"
+)
+
+// countingStatsHandler counts the RPCs tagged by the server.
+type countingStatsHandler struct {
+	m       sync.Mutex
+	methods []string
+}
+
+func (h *countingStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.methods = append(h.methods, info.FullMethodName)
+	return ctx
+}
+
+func (h *countingStatsHandler) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (h *countingStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *countingStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+func TestGRPCServer_statsHandler(t *testing.T) {
+	h := &countingStatsHandler{}
+	server := &GRPCServer{
+		Plugins: map[string]Plugin{},
+		Server: func(opts []grpc.ServerOption) *grpc.Server {
+			return grpc.NewServer(append(opts, grpc.StatsHandler(h))...)
+		},
+		DoneCh: make(chan struct{}),
+		Stdout: new(bytes.Buffer),
+		Stderr: new(bytes.Buffer),
+		logger: slog.Default(),
+	}
+	if err := server.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(lis)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{
+		Service: GRPCServiceName,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+	if len(h.methods) != 1 || h.methods[0] != "/grpc.health.v1.Health/Check" {
+		t.Fatalf("stats handler tagged %v, want one health check", h.methods)
+	}
+}
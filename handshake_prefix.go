@@ -0,0 +1,37 @@
+package plugin
+
+import (
+	"strings"
+)
+
+// handshakeFilter tells the handshake lines of the plugin's stdout from
+// other output when ClientConfig.HandshakePrefix is set.
+type handshakeFilter struct {
+	prefix string
+	lines  int
+
+	// legacy is set once the plugin printed a handshake without the
+	// prefix, because it was built with a version of this package that
+	// doesn't support it.
+	legacy bool
+}
+
+// filter returns the handshake carried by line, without the prefix, and
+// reports whether line is a handshake at all.
+func (f *handshakeFilter) filter(line string) (string, bool) {
+	f.lines++
+	if f.prefix == "" || f.legacy {
+		return line, true
+	}
+	if rest, ok := strings.CutPrefix(line, f.prefix); ok {
+		return rest, true
+	}
+
+	// A plugin that doesn't know about the prefix prints its handshake
+	// as the first line.
+	if f.lines == 1 && strings.Count(line, "|") >= 3 {
+		f.legacy = true
+		return line, true
+	}
+	return "", false
+}
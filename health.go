@@ -0,0 +1,204 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// WaitHealthy blocks until the plugin's health service reports SERVING, or
+// ctx is done. Unlike Start, which returns as soon as the plugin completed
+// the handshake, it is meant for plugins that report NOT_SERVING while they
+// initialize, or that become temporarily unavailable. The health service is
+// polled with an exponential backoff.
+func (c *Client) WaitHealthy(ctx context.Context) error {
+	return retryWithBackoff(ctx, func() error {
+		return c.HealthCheck(ctx)
+	})
+}
+
+// HealthCheck probes the plugin's health service once, and returns an error
+// unless it reports SERVING, e.g. to liveness-probe the plugin between RPCs.
+// For a process started with ClientConfig.MultiplexHandshakes, every plugin
+// must be serving.
+func (c *Client) HealthCheck(ctx context.Context) error {
+	client, err := c.protocolClient()
+	if err != nil {
+		return err
+	}
+	return checkClient(ctx, client)
+}
+
+// checkClient probes the health service of the plugin behind client once.
+// Protocols without one are pinged, and given up on when ctx is done.
+func checkClient(ctx context.Context, client ClientProtocol) error {
+	switch client := client.(type) {
+	case *GRPCClient:
+		return checkServing(ctx, client.Conn)
+	case *multiplexedClient:
+		for name, cl := range client.clients {
+			if err := checkServing(ctx, cl.Conn); err != nil {
+				return fmt.Errorf("plugin %q: %w", name, err)
+			}
+		}
+		return nil
+	default:
+		done := make(chan error, 1)
+		go func() { done <- client.Ping() }()
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// WatchHealth streams the status of the plugin's health service, using its
+// Watch RPC, so the host can react as soon as the plugin stops serving
+// instead of polling it. The current status is sent first, then every
+// change, until ctx is done or the stream fails, e.g. because the plugin
+// exited, after which the channel is closed. For a process started with
+// ClientConfig.MultiplexHandshakes, the status is SERVING only while every
+// plugin is serving.
+func (c *Client) WatchHealth(ctx context.Context) (<-chan grpc_health_v1.HealthCheckResponse_ServingStatus, error) {
+	client, err := c.protocolClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var conns []*grpc.ClientConn
+	switch client := client.(type) {
+	case *GRPCClient:
+		conns = append(conns, client.Conn)
+	case *multiplexedClient:
+		for _, cl := range client.clients {
+			conns = append(conns, cl.Conn)
+		}
+	default:
+		return nil, fmt.Errorf("plugin client %T has no health service", client)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	streams := make([]grpc_health_v1.Health_WatchClient, len(conns))
+	for i, conn := range conns {
+		stream, err := grpc_health_v1.NewHealthClient(conn).Watch(ctx, &grpc_health_v1.HealthCheckRequest{
+			Service: GRPCServiceName,
+		})
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		streams[i] = stream
+	}
+
+	type update struct {
+		i      int
+		status grpc_health_v1.HealthCheckResponse_ServingStatus
+	}
+	updates := make(chan update)
+	var wg sync.WaitGroup
+	for i, stream := range streams {
+		wg.Add(1)
+		go func(i int, stream grpc_health_v1.Health_WatchClient) {
+			defer wg.Done()
+			// A failed stream ends the watch of all the plugins.
+			defer cancel()
+			for {
+				resp, err := stream.Recv()
+				if err != nil {
+					return
+				}
+				select {
+				case updates <- update{i, resp.Status}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(i, stream)
+	}
+	go func() {
+		wg.Wait()
+		close(updates)
+	}()
+
+	ch := make(chan grpc_health_v1.HealthCheckResponse_ServingStatus)
+	go func() {
+		defer close(ch)
+		defer cancel()
+
+		statuses := make([]grpc_health_v1.HealthCheckResponse_ServingStatus, len(streams))
+		var last grpc_health_v1.HealthCheckResponse_ServingStatus
+		sent := false
+		for u := range updates {
+			statuses[u.i] = u.status
+			status := aggregateServingStatus(statuses)
+			if sent && status == last {
+				continue
+			}
+			select {
+			case ch <- status:
+				last, sent = status, true
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// aggregateServingStatus returns SERVING if all of statuses are, UNKNOWN
+// while some are not known yet, and NOT_SERVING otherwise.
+func aggregateServingStatus(statuses []grpc_health_v1.HealthCheckResponse_ServingStatus) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	status := grpc_health_v1.HealthCheckResponse_SERVING
+	for _, s := range statuses {
+		switch s {
+		case grpc_health_v1.HealthCheckResponse_SERVING:
+		case grpc_health_v1.HealthCheckResponse_UNKNOWN:
+			status = grpc_health_v1.HealthCheckResponse_UNKNOWN
+		default:
+			return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+		}
+	}
+	return status
+}
+
+// checkServing returns an error unless the health service on conn reports
+// that the plugin is serving.
+func checkServing(ctx context.Context, conn *grpc.ClientConn) error {
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{
+		Service: GRPCServiceName,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("plugin is %s", resp.Status)
+	}
+	return nil
+}
+
+// retryWithBackoff calls fn until it succeeds or ctx is done, doubling the
+// wait between attempts up to a second.
+func retryWithBackoff(ctx context.Context, fn func() error) error {
+	backoff := 50 * time.Millisecond
+	for {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s", ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+		if backoff < time.Second {
+			backoff *= 2
+		}
+	}
+}
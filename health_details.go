@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/kform-dev/plugin/internal/plugin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrHealthDetailsUnsupported is returned by Client.HealthDetails when the
+// plugin doesn't set ServeConfig.HealthReporter.
+var ErrHealthDetailsUnsupported = errors.New("plugin does not support health details")
+
+// HealthStatus is the status of a plugin or of one of its components.
+type HealthStatus string
+
+const (
+	HealthStatusServing    HealthStatus = "SERVING"
+	HealthStatusDegraded   HealthStatus = "DEGRADED"
+	HealthStatusNotServing HealthStatus = "NOT_SERVING"
+)
+
+// severity orders the statuses from healthy to unhealthy. Unknown statuses
+// are treated as not serving.
+func (s HealthStatus) severity() int {
+	switch s {
+	case HealthStatusServing:
+		return 0
+	case HealthStatusDegraded:
+		return 1
+	}
+	return 2
+}
+
+// ComponentHealth is the health of a single component of a plugin, e.g. a
+// backend connection or a cache.
+type ComponentHealth struct {
+	Name    string
+	Status  HealthStatus
+	Message string
+}
+
+// HealthReport is the detailed health reported by a plugin.
+type HealthReport struct {
+	// Status is the overall status of the plugin.
+	Status HealthStatus
+
+	// Message is a human readable summary of the health of the plugin.
+	Message string
+
+	// DegradedReasons lists why the plugin isn't fully serving, if it
+	// isn't.
+	DegradedReasons []string
+
+	// Components holds the health of the components of the plugin.
+	Components []ComponentHealth
+}
+
+// HealthReporter is implemented by plugins that can report more than the
+// SERVING/NOT_SERVING status of the gRPC health service when the host calls
+// Client.HealthDetails.
+type HealthReporter interface {
+	HealthReport(ctx context.Context) (*HealthReport, error)
+}
+
+// HealthReporterFunc adapts a function to a HealthReporter.
+type HealthReporterFunc func(ctx context.Context) (*HealthReport, error)
+
+// HealthReport implements HealthReporter.
+func (f HealthReporterFunc) HealthReport(ctx context.Context) (*HealthReport, error) {
+	return f(ctx)
+}
+
+// HealthDetails asks the plugin for a detailed report of its health. It
+// returns ErrHealthDetailsUnsupported if the plugin doesn't implement
+// HealthReporter. For a process started with ClientConfig.MultiplexHandshakes,
+// the reports of the plugins are merged: the status is the worst one
+// reported, and the messages, reasons and component names are prefixed with
+// the name of the plugin they were reported by.
+func (c *Client) HealthDetails(ctx context.Context) (*HealthReport, error) {
+	client, err := c.protocolClient()
+	if err != nil {
+		return nil, err
+	}
+
+	switch client := client.(type) {
+	case *GRPCClient:
+		return healthDetails(ctx, client.controller)
+	case *multiplexedClient:
+		names := make([]string, 0, len(client.clients))
+		for name := range client.clients {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		merged := &HealthReport{Status: HealthStatusServing}
+		for _, name := range names {
+			report, err := healthDetails(ctx, client.clients[name].controller)
+			if err != nil {
+				return nil, fmt.Errorf("plugin %q: %w", name, err)
+			}
+			merged.merge(name, report)
+		}
+		return merged, nil
+	default:
+		return nil, ErrHealthDetailsUnsupported
+	}
+}
+
+// merge adds the report of the named plugin to r.
+func (r *HealthReport) merge(name string, report *HealthReport) {
+	if report.Status.severity() > r.Status.severity() {
+		r.Status = report.Status
+	}
+	if report.Message != "" {
+		if r.Message != "" {
+			r.Message += "; "
+		}
+		r.Message += name + ": " + report.Message
+	}
+	for _, reason := range report.DegradedReasons {
+		r.DegradedReasons = append(r.DegradedReasons, name+": "+reason)
+	}
+	for _, comp := range report.Components {
+		comp.Name = name + "." + comp.Name
+		r.Components = append(r.Components, comp)
+	}
+}
+
+// healthDetails calls the HealthDetails RPC of the controller.
+func healthDetails(ctx context.Context, controller plugin.GRPCControllerClient) (*HealthReport, error) {
+	resp, err := controller.HealthDetails(ctx, &plugin.Empty{})
+	if status.Code(err) == codes.Unimplemented {
+		return nil, ErrHealthDetailsUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report := &HealthReport{
+		Status:          HealthStatus(resp.Status),
+		Message:         resp.Message,
+		DegradedReasons: resp.DegradedReasons,
+	}
+	for _, comp := range resp.Components {
+		report.Components = append(report.Components, ComponentHealth{
+			Name:    comp.Name,
+			Status:  HealthStatus(comp.Status),
+			Message: comp.Message,
+		})
+	}
+	return report, nil
+}
+
+// healthDetailsResponse converts a report to its wire representation.
+func healthDetailsResponse(report *HealthReport) *plugin.HealthDetailsResponse {
+	resp := &plugin.HealthDetailsResponse{
+		Status:          string(report.Status),
+		Message:         report.Message,
+		DegradedReasons: report.DegradedReasons,
+	}
+	for _, comp := range report.Components {
+		resp.Components = append(resp.Components, &plugin.ComponentHealth{
+			Name:    comp.Name,
+			Status:  string(comp.Status),
+			Message: comp.Message,
+		})
+	}
+	return resp
+}
@@ -0,0 +1,33 @@
+package plugin
+
+import (
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// HealthServer is the health service of a plugin, through which it reports
+// whether it is serving to Client.HealthCheck, Client.WaitHealthy and
+// Client.WatchHealth, e.g. to report NOT_SERVING while it initializes. Set
+// it as ServeConfig.HealthServer and keep it to change the status while the
+// plugin runs.
+type HealthServer struct {
+	server *health.Server
+}
+
+// NewHealthServer returns a health service reporting that the plugin is
+// serving, or not.
+func NewHealthServer(serving bool) *HealthServer {
+	s := &HealthServer{server: health.NewServer()}
+	s.SetServing(serving)
+	return s
+}
+
+// SetServing sets whether the plugin is serving. Hosts watching the health
+// of the plugin are sent the change.
+func (s *HealthServer) SetServing(serving bool) {
+	status := grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = grpc_health_v1.HealthCheckResponse_SERVING
+	}
+	s.server.SetServingStatus(GRPCServiceName, status)
+}
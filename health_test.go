@@ -0,0 +1,51 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestClientWaitHealthy(t *testing.T) {
+	c := testStartClient(t, testClientConfig("becomes-serving"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.HealthCheck(ctx); err == nil {
+		t.Fatal("plugin reported serving before it initialized")
+	}
+	if err := c.WaitHealthy(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.HealthCheck(ctx); err != nil {
+		t.Fatalf("plugin is not serving after WaitHealthy: %s", err)
+	}
+}
+
+func TestClientWatchHealth(t *testing.T) {
+	c := testStartClient(t, testClientConfig("becomes-serving"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ch, err := c.WatchHealth(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []grpc_health_v1.HealthCheckResponse_ServingStatus{
+		grpc_health_v1.HealthCheckResponse_NOT_SERVING,
+		grpc_health_v1.HealthCheckResponse_SERVING,
+	}
+	for _, status := range want {
+		select {
+		case got := <-ch:
+			if got != status {
+				t.Fatalf("watched %s, want %s", got, status)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timeout waiting for %s", status)
+		}
+	}
+}
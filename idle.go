@@ -0,0 +1,103 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// idleTracker calls onIdle once no RPC has been in flight for timeout.
+type idleTracker struct {
+	timeout time.Duration
+	onIdle  func()
+
+	m       sync.Mutex
+	timer   *time.Timer
+	active  int
+	stopped bool
+}
+
+func newIdleTracker(timeout time.Duration, onIdle func()) *idleTracker {
+	t := &idleTracker{timeout: timeout, onIdle: onIdle}
+	t.timer = time.AfterFunc(timeout, t.fire)
+	return t
+}
+
+// begin records the start of an RPC.
+func (t *idleTracker) begin() {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.active++
+	t.timer.Stop()
+}
+
+// end records the end of an RPC, restarting the idle period if it was the
+// last one in flight.
+func (t *idleTracker) end() {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.active--
+	if t.active == 0 && !t.stopped {
+		t.timer.Reset(t.timeout)
+	}
+}
+
+// stop disarms the tracker for good.
+func (t *idleTracker) stop() {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.stopped = true
+	t.timer.Stop()
+}
+
+func (t *idleTracker) fire() {
+	t.m.Lock()
+	idle := t.active == 0 && !t.stopped
+	t.stopped = t.stopped || idle
+	t.m.Unlock()
+
+	if idle {
+		t.onIdle()
+	}
+}
+
+// tracked reports whether calls to method count as using the plugin. Calls
+// the client makes on its own, to the controller and the health service, do
+// not, and neither do the stdio and broker streams, which stay open for as
+// long as the connection.
+func (t *idleTracker) tracked(method string) bool {
+	return !strings.HasPrefix(method, controllerMethodPrefix) &&
+		!strings.HasPrefix(method, "/grpc.health.v1.Health/") &&
+		!strings.HasPrefix(method, "/plugin.GRPCStdio/") &&
+		!strings.HasPrefix(method, "/plugin.GRPCBroker/")
+}
+
+func (t *idleTracker) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if !t.tracked(method) {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	t.begin()
+	defer t.end()
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func (t *idleTracker) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if !t.tracked(method) {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+
+	t.begin()
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		t.end()
+		return nil, err
+	}
+	return newDoneStream(ctx, stream, desc, t.end), nil
+}
@@ -0,0 +1,76 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// testWaitExited waits for the plugin of c to exit.
+func testWaitExited(c *Client, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if c.Exited() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestClientIdleTimeout(t *testing.T) {
+	const idleTimeout = 300 * time.Millisecond
+
+	cases := []struct {
+		name string
+		// call starts a call that keeps the plugin in use until ctx is
+		// done, if set.
+		call func(ctx context.Context, conn *grpc.ClientConn) error
+	}{
+		{"idle", nil},
+		{"unary call", func(ctx context.Context, conn *grpc.ClientConn) error {
+			go testCall(ctx, conn, "Block", new(emptypb.Empty), new(emptypb.Empty))
+			return nil
+		}},
+		{"stream", func(ctx context.Context, conn *grpc.ClientConn) error {
+			stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/"+testServiceName+"/Stream")
+			if err != nil {
+				return err
+			}
+			if err := stream.SendMsg(new(emptypb.Empty)); err != nil {
+				return err
+			}
+			if err := stream.CloseSend(); err != nil {
+				return err
+			}
+			return stream.RecvMsg(new(emptypb.Empty))
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := testClientConfig("test-grpc")
+			config.IdleTimeout = idleTimeout
+			c := testStartClient(t, config)
+			conn := testDispense(t, c)
+
+			if tc.call != nil {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				if err := tc.call(ctx, conn); err != nil {
+					t.Fatalf("err: %s", err)
+				}
+				if testWaitExited(c, 4*idleTimeout) {
+					t.Fatal("plugin was killed while in use")
+				}
+				cancel()
+			}
+
+			if !testWaitExited(c, 10*time.Second) {
+				t.Fatal("idle plugin was not killed")
+			}
+		})
+	}
+}
@@ -23,6 +23,9 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type GRPCControllerClient interface {
 	Shutdown(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error)
+	Cancel(ctx context.Context, in *CancelRequest, opts ...grpc.CallOption) (*Empty, error)
+	DebugDump(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*DebugDumpResponse, error)
+	HealthDetails(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*HealthDetailsResponse, error)
 }
 
 type gRPCControllerClient struct {
@@ -42,11 +45,41 @@ func (c *gRPCControllerClient) Shutdown(ctx context.Context, in *Empty, opts ...
 	return out, nil
 }
 
+func (c *gRPCControllerClient) Cancel(ctx context.Context, in *CancelRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/plugin.GRPCController/Cancel", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gRPCControllerClient) DebugDump(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*DebugDumpResponse, error) {
+	out := new(DebugDumpResponse)
+	err := c.cc.Invoke(ctx, "/plugin.GRPCController/DebugDump", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gRPCControllerClient) HealthDetails(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*HealthDetailsResponse, error) {
+	out := new(HealthDetailsResponse)
+	err := c.cc.Invoke(ctx, "/plugin.GRPCController/HealthDetails", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // GRPCControllerServer is the server API for GRPCController service.
 // All implementations must embed UnimplementedGRPCControllerServer
 // for forward compatibility
 type GRPCControllerServer interface {
 	Shutdown(context.Context, *Empty) (*Empty, error)
+	Cancel(context.Context, *CancelRequest) (*Empty, error)
+	DebugDump(context.Context, *Empty) (*DebugDumpResponse, error)
+	HealthDetails(context.Context, *Empty) (*HealthDetailsResponse, error)
 	mustEmbedUnimplementedGRPCControllerServer()
 }
 
@@ -57,6 +90,15 @@ type UnimplementedGRPCControllerServer struct {
 func (UnimplementedGRPCControllerServer) Shutdown(context.Context, *Empty) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Shutdown not implemented")
 }
+func (UnimplementedGRPCControllerServer) Cancel(context.Context, *CancelRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Cancel not implemented")
+}
+func (UnimplementedGRPCControllerServer) DebugDump(context.Context, *Empty) (*DebugDumpResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DebugDump not implemented")
+}
+func (UnimplementedGRPCControllerServer) HealthDetails(context.Context, *Empty) (*HealthDetailsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method HealthDetails not implemented")
+}
 func (UnimplementedGRPCControllerServer) mustEmbedUnimplementedGRPCControllerServer() {}
 
 // UnsafeGRPCControllerServer may be embedded to opt out of forward compatibility for this service.
@@ -88,6 +130,60 @@ func _GRPCController_Shutdown_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _GRPCController_Cancel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GRPCControllerServer).Cancel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/plugin.GRPCController/Cancel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GRPCControllerServer).Cancel(ctx, req.(*CancelRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GRPCController_DebugDump_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GRPCControllerServer).DebugDump(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/plugin.GRPCController/DebugDump",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GRPCControllerServer).DebugDump(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GRPCController_HealthDetails_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GRPCControllerServer).HealthDetails(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/plugin.GRPCController/HealthDetails",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GRPCControllerServer).HealthDetails(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // GRPCController_ServiceDesc is the grpc.ServiceDesc for GRPCController service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -99,6 +195,18 @@ var GRPCController_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Shutdown",
 			Handler:    _GRPCController_Shutdown_Handler,
 		},
+		{
+			MethodName: "Cancel",
+			Handler:    _GRPCController_Cancel_Handler,
+		},
+		{
+			MethodName: "DebugDump",
+			Handler:    _GRPCController_DebugDump_Handler,
+		},
+		{
+			MethodName: "HealthDetails",
+			Handler:    _GRPCController_HealthDetails_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "grpc_controller.proto",
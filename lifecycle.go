@@ -0,0 +1,50 @@
+package plugin
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// LifecycleEventKind is the kind of a LifecycleEvent.
+type LifecycleEventKind string
+
+// Lifecycle event kinds.
+const (
+	// LifecycleStarted is reported once the plugin completed its handshake.
+	// Duration is how long it took from launching the process.
+	LifecycleStarted LifecycleEventKind = "started"
+
+	// LifecycleExited is reported when the plugin process exited. Duration
+	// is how long it ran, and Err is the error it exited with, if any.
+	LifecycleExited LifecycleEventKind = "exited"
+
+	// LifecycleRestarted is reported when AutoRestart relaunched the plugin
+	// after a crash. Duration is how long the restart took.
+	LifecycleRestarted LifecycleEventKind = "restarted"
+)
+
+// LifecycleEvent is reported to ClientConfig.OnLifecycleEvent, e.g. to
+// record metrics about plugin starts, exits and restarts.
+type LifecycleEvent struct {
+	Kind LifecycleEventKind
+
+	// Plugin is the base name of the plugin executable.
+	Plugin string
+
+	Duration time.Duration
+	Err      error
+}
+
+// lifecycle reports a lifecycle event to ClientConfig.OnLifecycleEvent, if
+// set.
+func (c *Client) lifecycle(kind LifecycleEventKind, name string, d time.Duration, err error) {
+	if c.config.OnLifecycleEvent == nil {
+		return
+	}
+	c.config.OnLifecycleEvent(LifecycleEvent{
+		Kind:     kind,
+		Plugin:   filepath.Base(name),
+		Duration: d,
+		Err:      err,
+	})
+}
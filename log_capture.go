@@ -0,0 +1,149 @@
+package plugin
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CapturedRecord is a log record recorded by a LogCapture.
+type CapturedRecord struct {
+	Time    time.Time
+	Level   slog.Level
+	Message string
+
+	// Attrs holds the record attributes, including those added with
+	// Logger.With. Attributes inside groups are keyed by their dotted path.
+	Attrs map[string]any
+}
+
+// LogCapture records the log records of a logger created by CaptureLogs so
+// tests can assert on what was logged.
+type LogCapture struct {
+	m       sync.Mutex
+	records []CapturedRecord
+}
+
+// CaptureLogs returns a logger that records every record it handles, at all
+// levels, into the returned LogCapture. It is intended to be used as
+// ClientConfig.Logger or ServeConfig.Logger in tests.
+func CaptureLogs() (*slog.Logger, *LogCapture) {
+	c := &LogCapture{}
+	return slog.New(&captureHandler{capture: c}), c
+}
+
+// Records returns a copy of all records captured so far.
+func (c *LogCapture) Records() []CapturedRecord {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return append([]CapturedRecord(nil), c.records...)
+}
+
+// Find returns the first captured record with the given message.
+func (c *LogCapture) Find(msg string) (CapturedRecord, bool) {
+	for _, r := range c.Records() {
+		if r.Message == msg {
+			return r, true
+		}
+	}
+	return CapturedRecord{}, false
+}
+
+// Reset discards all captured records.
+func (c *LogCapture) Reset() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.records = nil
+}
+
+func (c *LogCapture) add(r CapturedRecord) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.records = append(c.records, r)
+}
+
+// captureHandler is a slog.Handler that records into a LogCapture.
+type captureHandler struct {
+	capture *LogCapture
+	attrs   []slog.Attr
+	groups  []string
+}
+
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *captureHandler) Handle(_ context.Context, r slog.Record) error {
+	rec := CapturedRecord{
+		Time:    r.Time,
+		Level:   r.Level,
+		Message: r.Message,
+		Attrs:   make(map[string]any),
+	}
+	for _, a := range h.attrs {
+		addAttr(rec.Attrs, "", a)
+	}
+	prefix := strings.Join(h.groups, ".")
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(rec.Attrs, prefix, a)
+		return true
+	})
+
+	h.capture.add(rec)
+	return nil
+}
+
+func (h *captureHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	// Attributes added here are qualified by the groups opened so far.
+	prefix := strings.Join(h.groups, ".")
+	qualified := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		if prefix != "" {
+			a.Key = prefix + "." + a.Key
+		}
+		qualified = append(qualified, a)
+	}
+
+	return &captureHandler{
+		capture: h.capture,
+		attrs:   append(append([]slog.Attr(nil), h.attrs...), qualified...),
+		groups:  h.groups,
+	}
+}
+
+func (h *captureHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &captureHandler{
+		capture: h.capture,
+		attrs:   h.attrs,
+		groups:  append(append([]string(nil), h.groups...), name),
+	}
+}
+
+// addAttr adds the attribute to attrs, flattening groups into dotted keys.
+func addAttr(attrs map[string]any, prefix string, a slog.Attr) {
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+
+	v := a.Value.Resolve()
+	key := a.Key
+	if prefix != "" && key != "" {
+		key = prefix + "." + key
+	} else if key == "" {
+		key = prefix
+	}
+
+	if v.Kind() == slog.KindGroup {
+		for _, ga := range v.Group() {
+			addAttr(attrs, key, ga)
+		}
+		return
+	}
+	attrs[key] = v.Any()
+}
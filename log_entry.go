@@ -5,26 +5,28 @@ package plugin
 
 import (
 	"encoding/json"
+	"log/slog"
+	"strings"
 	"time"
 )
 
-// logEntry is the JSON payload that gets sent to Stderr from the plugin to the host
-type logEntry struct {
+// LogEntry is the JSON payload that gets sent to Stderr from the plugin to the host
+type LogEntry struct {
 	Message   string        `json:"@message"`
 	Level     string        `json:"@level"`
 	Timestamp time.Time     `json:"timestamp"`
-	KVPairs   []*logEntryKV `json:"kv_pairs"`
+	KVPairs   []*LogEntryKV `json:"kv_pairs"`
 }
 
-// logEntryKV is a key value pair within the Output payload
-type logEntryKV struct {
+// LogEntryKV is a key value pair within the Output payload
+type LogEntryKV struct {
 	Key   string      `json:"key"`
 	Value interface{} `json:"value"`
 }
 
 // flattenKVPairs is used to flatten KVPair slice into []interface{}
 // for hclog consumption.
-func flattenKVPairs(kvs []*logEntryKV) []interface{} {
+func flattenKVPairs(kvs []*LogEntryKV) []interface{} {
 	var result []interface{}
 	for _, kv := range kvs {
 		result = append(result, kv.Key)
@@ -34,10 +36,49 @@ func flattenKVPairs(kvs []*logEntryKV) []interface{} {
 	return result
 }
 
+// logEntryLevel maps the level of a JSON log entry to a slog level. Trace is
+// logged as Debug, since slog has no lower level. It reports false for
+// entries without a known level.
+func logEntryLevel(level string) (slog.Level, bool) {
+	switch strings.ToLower(level) {
+	case "trace", "debug":
+		return slog.LevelDebug, true
+	case "info":
+		return slog.LevelInfo, true
+	case "warn", "warning":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	}
+	return 0, false
+}
+
+// defaultStderrLevelPrefixes are the prefixes of non-JSON stderr lines used
+// to infer their level when ClientConfig.StderrLevelPrefixes is unset.
+var defaultStderrLevelPrefixes = map[string]slog.Level{
+	"[TRACE]": slog.LevelDebug,
+	"[DEBUG]": slog.LevelDebug,
+	"[INFO]":  slog.LevelInfo,
+	"[WARN]":  slog.LevelWarn,
+	"[ERROR]": slog.LevelError,
+}
+
+// prefixLevel returns the level of the longest of prefixes line starts with,
+// or Debug if it starts with none of them.
+func prefixLevel(prefixes map[string]slog.Level, line string) slog.Level {
+	level, longest := slog.LevelDebug, -1
+	for prefix, l := range prefixes {
+		if len(prefix) > longest && strings.HasPrefix(line, prefix) {
+			level, longest = l, len(prefix)
+		}
+	}
+	return level
+}
+
 // parseJSON handles parsing JSON output
-func parseJSON(input []byte) (*logEntry, error) {
+func parseJSON(input []byte) (*LogEntry, error) {
 	var raw map[string]interface{}
-	entry := &logEntry{}
+	entry := &LogEntry{}
 
 	err := json.Unmarshal(input, &raw)
 	if err != nil {
@@ -66,7 +107,7 @@ func parseJSON(input []byte) (*logEntry, error) {
 
 	// Parse dynamic KV args from the hclog payload.
 	for k, v := range raw {
-		entry.KVPairs = append(entry.KVPairs, &logEntryKV{
+		entry.KVPairs = append(entry.KVPairs, &LogEntryKV{
 			Key:   k,
 			Value: v,
 		})
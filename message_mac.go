@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// messageMACKey is the metadata key carrying the MAC of a message.
+const messageMACKey = "plugin-mac"
+
+// Directions a message MAC is computed for, so a response MAC can't be
+// passed off as a request MAC.
+const (
+	macRequest  = "request"
+	macResponse = "response"
+)
+
+// deriveMACKey derives the key used to MAC messages from the magic cookie
+// both the host and the plugin know.
+func deriveMACKey(cookie HandshakeConfig) []byte {
+	h := hmac.New(sha256.New, []byte(cookie.MagicCookieValue))
+	h.Write([]byte("plugin message mac\x00"))
+	h.Write([]byte(cookie.MagicCookieKey))
+	return h.Sum(nil)
+}
+
+// messageMAC computes the MAC of a message sent for method in direction.
+func messageMAC(key []byte, direction, method string, msg interface{}) (string, error) {
+	var m proto.Message
+	switch msg := msg.(type) {
+	case proto.Message:
+		m = msg
+	case protoadapt.MessageV1:
+		m = protoadapt.MessageV2Of(msg)
+	default:
+		return "", fmt.Errorf("cannot compute the MAC of non protobuf message %T", msg)
+	}
+
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(direction))
+	h.Write([]byte{0})
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write(b)
+	return base64.RawStdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyMessageMAC checks that macs holds the MAC of msg.
+func verifyMessageMAC(key []byte, direction, method string, msg interface{}, macs []string) error {
+	if len(macs) != 1 {
+		return status.Errorf(codes.Unauthenticated, "missing %s MAC", direction)
+	}
+
+	want, err := messageMAC(key, direction, method, msg)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if !hmac.Equal([]byte(macs[0]), []byte(want)) {
+		return status.Errorf(codes.Unauthenticated, "invalid %s MAC", direction)
+	}
+	return nil
+}
+
+// macUnaryClientInterceptor MACs the requests of unary calls and verifies
+// the MAC of their responses.
+func macUnaryClientInterceptor(key []byte) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		mac, err := messageMAC(key, macRequest, method, req)
+		if err != nil {
+			return err
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, messageMACKey, mac)
+
+		var trailer metadata.MD
+		if err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Trailer(&trailer))...); err != nil {
+			return err
+		}
+		return verifyMessageMAC(key, macResponse, method, reply, trailer.Get(messageMACKey))
+	}
+}
+
+// macUnaryServerInterceptor verifies the MAC of the requests of unary calls
+// and MACs their responses.
+func macUnaryServerInterceptor(key []byte) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if err := verifyMessageMAC(key, macRequest, info.FullMethod, req, md.Get(messageMACKey)); err != nil {
+			return nil, err
+		}
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+
+		mac, err := messageMAC(key, macResponse, info.FullMethod, resp)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if err := grpc.SetTrailer(ctx, metadata.Pairs(messageMACKey, mac)); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
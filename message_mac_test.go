@@ -0,0 +1,101 @@
+package plugin
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// tamperRequest changes the request after its MAC was computed.
+func tamperRequest(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	req.(*grpc_health_v1.HealthCheckRequest).Service = "tampered"
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// tamperResponse changes the response before its MAC is verified.
+func tamperResponse(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	reply.(*grpc_health_v1.HealthCheckResponse).Status = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	return err
+}
+
+func TestMessageMAC(t *testing.T) {
+	key := deriveMACKey(testHandshake)
+	otherKey := deriveMACKey(HandshakeConfig{MagicCookieKey: "OTHER", MagicCookieValue: "other"})
+
+	cases := []struct {
+		name      string
+		serverKey []byte
+		client    []grpc.UnaryClientInterceptor
+		code      codes.Code
+	}{
+		{"round trip", key, []grpc.UnaryClientInterceptor{macUnaryClientInterceptor(key)}, codes.OK},
+		{"without mac", key, nil, codes.Unauthenticated},
+		{"other key", key, []grpc.UnaryClientInterceptor{macUnaryClientInterceptor(otherKey)}, codes.Unauthenticated},
+		{"tampered request", key, []grpc.UnaryClientInterceptor{macUnaryClientInterceptor(key), tamperRequest}, codes.Unauthenticated},
+		{"tampered response", key, []grpc.UnaryClientInterceptor{macUnaryClientInterceptor(key), tamperResponse}, codes.Unauthenticated},
+		{"unsigned response", nil, []grpc.UnaryClientInterceptor{macUnaryClientInterceptor(key)}, codes.Unauthenticated},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var opts []grpc.ServerOption
+			if tc.serverKey != nil {
+				opts = append(opts, grpc.UnaryInterceptor(macUnaryServerInterceptor(tc.serverKey)))
+			}
+			server := grpc.NewServer(opts...)
+			grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go server.Serve(lis)
+			defer server.Stop()
+
+			conn, err := grpc.NewClient(lis.Addr().String(),
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				grpc.WithChainUnaryInterceptor(tc.client...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+			if code := status.Code(err); code != tc.code {
+				t.Fatalf("call failed with %v, want %s", err, tc.code)
+			}
+		})
+	}
+}
+
+func TestClientMessageMAC(t *testing.T) {
+	cases := []struct {
+		name string
+		mac  bool
+		ok   bool
+	}{
+		{"enabled", true, true},
+		{"disabled on the client", false, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := testClientConfig("mac")
+			config.MessageMAC = tc.mac
+			c := testStartClient(t, config)
+
+			client, err := c.Client()
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if err := client.Ping(); (err == nil) != tc.ok {
+				t.Fatalf("ping: %v, want success %t", err, tc.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// Package metrics provides Prometheus metrics for plugin RPCs.
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Role is the side of the connection a Latency collector measures.
+type Role string
+
+const (
+	// RoleClient measures calls made by the host to the plugin, as seen by
+	// the host.
+	RoleClient Role = "client"
+	// RoleServer measures calls handled by the plugin.
+	RoleServer Role = "server"
+)
+
+// Latency is a prometheus.Collector holding a histogram of RPC latency in
+// seconds, labeled by gRPC method and status code. It records the calls
+// going through its interceptors.
+//
+// On the host, pass the client interceptors in ClientConfig.GRPCDialOptions:
+//
+//	lat := metrics.NewLatency(metrics.RoleClient)
+//	prometheus.MustRegister(lat)
+//	config.GRPCDialOptions = []grpc.DialOption{
+//		grpc.WithChainUnaryInterceptor(lat.UnaryClientInterceptor),
+//		grpc.WithChainStreamInterceptor(lat.StreamClientInterceptor),
+//	}
+//
+// In the plugin, pass the server interceptors to the server created by
+// ServeConfig.GRPCServer.
+type Latency struct {
+	hist *prometheus.HistogramVec
+}
+
+// NewLatency creates a Latency collector for the given role. Its histogram
+// is named plugin_<role>_rpc_duration_seconds.
+func NewLatency(role Role) *Latency {
+	return NewLatencyWithBuckets(role, prometheus.DefBuckets)
+}
+
+// NewLatencyWithBuckets is like NewLatency, with custom histogram buckets.
+func NewLatencyWithBuckets(role Role, buckets []float64) *Latency {
+	return &Latency{
+		hist: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "plugin",
+			Subsystem: string(role),
+			Name:      "rpc_duration_seconds",
+			Help:      "Latency of plugin RPCs by gRPC method and status code.",
+			Buckets:   buckets,
+		}, []string{"method", "code"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (l *Latency) Describe(ch chan<- *prometheus.Desc) {
+	l.hist.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (l *Latency) Collect(ch chan<- prometheus.Metric) {
+	l.hist.Collect(ch)
+}
+
+func (l *Latency) observe(method string, start time.Time, err error) {
+	l.hist.WithLabelValues(method, status.Code(err).String()).Observe(time.Since(start).Seconds())
+}
+
+// UnaryClientInterceptor records the latency of unary calls.
+func (l *Latency) UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	l.observe(method, start, err)
+	return err
+}
+
+// StreamClientInterceptor records the time it takes to open streams. The
+// lifetime of a stream depends on its consumer and is not recorded.
+func (l *Latency) StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	start := time.Now()
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	l.observe(method, start, err)
+	return stream, err
+}
+
+// UnaryServerInterceptor records the time spent handling unary calls.
+func (l *Latency) UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	l.observe(info.FullMethod, start, err)
+	return resp, err
+}
+
+// StreamServerInterceptor records the time spent handling streams.
+func (l *Latency) StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	l.observe(info.FullMethod, start, err)
+	return err
+}
@@ -5,23 +5,144 @@ package plugin
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
+	"fmt"
 	"math/big"
+	"os"
 	"time"
 )
 
+// ErrServerCertExpired is returned when the client would reconnect to a
+// plugin whose AutoMTLS certificate is no longer valid.
+var ErrServerCertExpired = errors.New("plugin server certificate expired")
+
+// checkServerCert returns ErrServerCertExpired if the certificate the plugin
+// presented during the AutoMTLS handshake is no longer valid. It is checked
+// whenever the client connects to the plugin, since the plugin keeps using
+// the certificate it generated at startup however long it runs.
+func (c *Client) checkServerCert() error {
+	cert := c.serverCert.Load()
+	if cert == nil {
+		return nil
+	}
+	now := time.Now()
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("%w: valid until %s", ErrServerCertExpired, cert.NotAfter.Format(time.RFC3339))
+	}
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("%w: not valid before %s", ErrServerCertExpired, cert.NotBefore.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// CertKeyType is the type of key of a certificate generated by AutoMTLS.
+type CertKeyType string
+
+// Supported AutoMTLS key types.
+const (
+	CertKeyECDSAP256 CertKeyType = "ecdsa-p256"
+	CertKeyECDSAP384 CertKeyType = "ecdsa-p384"
+	CertKeyECDSAP521 CertKeyType = "ecdsa-p521"
+	CertKeyRSA2048   CertKeyType = "rsa-2048"
+	CertKeyRSA3072   CertKeyType = "rsa-3072"
+	CertKeyRSA4096   CertKeyType = "rsa-4096"
+)
+
+// defaultCertNotAfter is the validity of AutoMTLS certificates when
+// AutoMTLSCertConfig.NotAfter is not set.
+const defaultCertNotAfter = 262980 * time.Hour
+
+// AutoMTLSCertConfig sets the parameters of the certificates generated by
+// AutoMTLS. The client passes it to the plugin through EnvAutoMTLSKeyType and
+// EnvAutoMTLSNotAfter, so both ends generate the same kind of certificate.
+type AutoMTLSCertConfig struct {
+	// KeyType is the type of key to generate. Defaults to CertKeyECDSAP521.
+	KeyType CertKeyType
+
+	// NotAfter is how long the certificate is valid for from its creation.
+	// Defaults to 30 years.
+	NotAfter time.Duration
+}
+
+// env returns the environment variables passing cfg to the plugin.
+func (cfg *AutoMTLSCertConfig) env() []string {
+	var env []string
+	if cfg.KeyType != "" {
+		env = append(env, fmt.Sprintf("%s=%s", EnvAutoMTLSKeyType, cfg.KeyType))
+	}
+	if cfg.NotAfter != 0 {
+		env = append(env, fmt.Sprintf("%s=%s", EnvAutoMTLSNotAfter, cfg.NotAfter))
+	}
+	return env
+}
+
+// autoMTLSCertConfigFromEnv returns the certificate config the client passed
+// to the plugin, or nil if it passed none.
+func autoMTLSCertConfigFromEnv() (*AutoMTLSCertConfig, error) {
+	keyType := os.Getenv(EnvAutoMTLSKeyType)
+	notAfter := os.Getenv(EnvAutoMTLSNotAfter)
+	if keyType == "" && notAfter == "" {
+		return nil, nil
+	}
+
+	cfg := &AutoMTLSCertConfig{KeyType: CertKeyType(keyType)}
+	if notAfter != "" {
+		d, err := time.ParseDuration(notAfter)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get value from %s: %v", EnvAutoMTLSNotAfter, err)
+		}
+		cfg.NotAfter = d
+	}
+	return cfg, nil
+}
+
+// generateKey generates a private key of the given type.
+func generateKey(keyType CertKeyType) (any, error) {
+	switch keyType {
+	case "", CertKeyECDSAP521:
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case CertKeyECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case CertKeyECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case CertKeyRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case CertKeyRSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case CertKeyRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	}
+	return nil, fmt.Errorf("unsupported AutoMTLS key type %q", keyType)
+}
+
 // generateCert generates a temporary certificate for plugin authentication. The
-// certificate and private key are returns in PEM format.
-func generateCert() (cert []byte, privateKey []byte, err error) {
-	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+// certificate and private key are returns in PEM format. A nil cfg uses the
+// defaults.
+func generateCert(cfg *AutoMTLSCertConfig) (cert []byte, privateKey []byte, err error) {
+	if cfg == nil {
+		cfg = &AutoMTLSCertConfig{}
+	}
+	if cfg.NotAfter < 0 {
+		return nil, nil, fmt.Errorf("invalid AutoMTLS certificate validity %s", cfg.NotAfter)
+	}
+	notAfter := cfg.NotAfter
+	if notAfter == 0 {
+		notAfter = defaultCertNotAfter
+	}
+
+	key, err := generateKey(cfg.KeyType)
 	if err != nil {
 		return nil, nil, err
 	}
+	signer := key.(interface{ Public() crypto.PublicKey })
 
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
 	sn, err := rand.Int(rand.Reader, serialNumberLimit)
@@ -45,11 +166,11 @@ func generateCert() (cert []byte, privateKey []byte, err error) {
 		BasicConstraintsValid: true,
 		SerialNumber:          sn,
 		NotBefore:             time.Now().Add(-30 * time.Second),
-		NotAfter:              time.Now().Add(262980 * time.Hour),
+		NotAfter:              time.Now().Add(notAfter),
 		IsCA:                  true,
 	}
 
-	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), key)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -59,13 +180,20 @@ func generateCert() (cert []byte, privateKey []byte, err error) {
 		return nil, nil, err
 	}
 
-	keyBytes, err := x509.MarshalECPrivateKey(key)
-	if err != nil {
-		return nil, nil, err
+	var keyBlock *pem.Block
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		keyBytes, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyBlock = &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}
+	case *rsa.PrivateKey:
+		keyBlock = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
 	}
 
 	var keyOut bytes.Buffer
-	if err := pem.Encode(&keyOut, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}); err != nil {
+	if err := pem.Encode(&keyOut, keyBlock); err != nil {
 		return nil, nil, err
 	}
 
@@ -0,0 +1,183 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/kform-dev/plugin/runner"
+	"google.golang.org/grpc"
+)
+
+// handshakePluginName returns the name of the plugin a multiplexed handshake
+// line is for, or an empty string if the line doesn't name one.
+func handshakePluginName(line string) string {
+	parts := splitHandshake(line)
+	if len(parts) < 8 {
+		return ""
+	}
+	return parts[7]
+}
+
+// addMultiplexedHandshake parses a handshake line of a process started with
+// ClientConfig.MultiplexHandshakes and records the address of the plugin it
+// is for. It reports whether a handshake was read for every negotiated
+// plugin.
+func (c *Client) addMultiplexedHandshake(runner runner.Runner, line string) (net.Addr, bool, error) {
+	name := handshakePluginName(line)
+	if name == "" {
+		return nil, false, fmt.Errorf("handshake does not name the plugin it serves: %s", strings.TrimSpace(line))
+	}
+
+	addr, err := c.parseHandshake(runner, line)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, ok := c.negotiatedPlugins[name]; !ok {
+		return nil, false, fmt.Errorf("handshake for unknown plugin %q", name)
+	}
+
+	if c.pluginAddrs == nil {
+		c.pluginAddrs = make(map[string]net.Addr)
+	}
+	c.pluginAddrs[name] = addr
+	c.logger.Debug("read plugin handshake", "name", name, "address", addr)
+
+	return addr, len(c.pluginAddrs) == len(c.negotiatedPlugins), nil
+}
+
+// missingHandshakes returns the sorted names of the negotiated plugins no
+// handshake was read for.
+func (c *Client) missingHandshakes() []string {
+	var missing []string
+	for name := range c.negotiatedPlugins {
+		if _, ok := c.pluginAddrs[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// multiplexedClient is the ClientProtocol of a process that printed a
+// handshake per plugin. It dispenses each plugin over its own connection, or
+// over a connection shared by the plugins served on the same address if
+// ClientConfig.ShareConnections is set.
+type multiplexedClient struct {
+	clients map[string]*GRPCClient
+
+	// conns holds each connection of clients once.
+	conns []*GRPCClient
+}
+
+// newMultiplexedClient connects to each of the plugins of c. The Client
+// argument is expected to be successfully started already with a lock held.
+func newMultiplexedClient(doneCtx context.Context, c *Client) (*multiplexedClient, error) {
+	// Group the plugins by the connection they are dispensed over.
+	groups := make(map[string]PluginSet)
+	addrs := make(map[string]net.Addr)
+	for name, addr := range c.pluginAddrs {
+		key := name
+		if c.config.ShareConnections {
+			key = addr.Network() + ":" + addr.String()
+		}
+		if groups[key] == nil {
+			groups[key] = make(PluginSet)
+		}
+		groups[key][name] = c.negotiatedPlugins[name]
+		addrs[key] = addr
+	}
+
+	mc := &multiplexedClient{clients: make(map[string]*GRPCClient, len(c.pluginAddrs))}
+	for key, plugins := range groups {
+		cl, err := dialGRPCClient(doneCtx, c, c.addrDialer(addrs[key]), plugins)
+		if err != nil {
+			mc.Close()
+			return nil, fmt.Errorf("connecting to plugin %q: %w", strings.Join(pluginNames(plugins), ", "), err)
+		}
+		mc.conns = append(mc.conns, cl)
+		for name := range plugins {
+			mc.clients[name] = cl
+		}
+	}
+	return mc, nil
+}
+
+// pluginNames returns the sorted names of the plugins.
+func pluginNames(plugins PluginSet) []string {
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ClientProtocol impl.
+func (c *multiplexedClient) Close() error {
+	var errs []error
+	for _, cl := range c.conns {
+		errs = append(errs, cl.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// disconnect closes the connections without asking the plugins to shut down.
+func (c *multiplexedClient) disconnect() error {
+	var errs []error
+	for _, cl := range c.conns {
+		errs = append(errs, cl.disconnect())
+	}
+	return errors.Join(errs...)
+}
+
+// ClientProtocol impl.
+func (c *multiplexedClient) Dispense(name string) (interface{}, error) {
+	cl, ok := c.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown plugin type: %s", name)
+	}
+	return cl.Dispense(name)
+}
+
+// ClientProtocol impl. It fails if any of the connections is unhealthy.
+func (c *multiplexedClient) Ping() error {
+	for _, cl := range c.conns {
+		if err := cl.Ping(); err != nil {
+			return fmt.Errorf("plugin %q: %w", strings.Join(pluginNames(cl.Plugins), ", "), err)
+		}
+	}
+	return nil
+}
+
+// SharedConnClient is the ClientProtocol returned by Client for a process
+// started with ClientConfig.MultiplexHandshakes and ShareConnections. The
+// plugins the process serves on the same address are dispensed over a
+// single gRPC connection, to save the file descriptors and memory of a
+// connection per plugin.
+type SharedConnClient struct {
+	*multiplexedClient
+}
+
+// Conn returns the gRPC connection the named plugin is dispensed over,
+// which is shared with the other plugins served on the same address.
+func (c *SharedConnClient) Conn(name string) (*grpc.ClientConn, error) {
+	cl, ok := c.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown plugin type: %s", name)
+	}
+	return cl.Conn, nil
+}
+
+// Plugins returns the sorted names of the plugins dispensed over the same
+// connection as the named plugin, including it.
+func (c *SharedConnClient) Plugins(name string) ([]string, error) {
+	cl, ok := c.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown plugin type: %s", name)
+	}
+	return pluginNames(cl.Plugins), nil
+}
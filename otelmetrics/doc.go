@@ -0,0 +1,18 @@
+// Package otelmetrics records plugin lifecycle metrics with the
+// OpenTelemetry metrics API, for hosts that export their metrics with
+// OpenTelemetry rather than Prometheus.
+//
+// The package depends on go.opentelemetry.io/otel/metric, so it is only
+// built with the otel build tag:
+//
+//	go build -tags otel
+//
+// Create a Recorder from the host's MeterProvider and pass its
+// OnLifecycleEvent method in ClientConfig.OnLifecycleEvent:
+//
+//	rec, err := otelmetrics.NewRecorder(otel.GetMeterProvider())
+//	if err != nil {
+//		return err
+//	}
+//	config.OnLifecycleEvent = rec.OnLifecycleEvent
+package otelmetrics
@@ -0,0 +1,90 @@
+//go:build otel
+
+package otelmetrics
+
+import (
+	"context"
+
+	"github.com/kform-dev/plugin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// meterName is the instrumentation scope of the recorded metrics.
+const meterName = "github.com/kform-dev/plugin"
+
+// Recorder records plugin lifecycle events as OpenTelemetry metrics:
+//
+//   - plugin.starts, plugin.exits and plugin.restarts count the events, by
+//     plugin; exits are also labeled by whether the plugin exited with an
+//     error.
+//   - plugin.start.duration is the time it took plugins to start, in
+//     seconds.
+//   - plugin.uptime is how long plugins ran before they exited, in seconds.
+//   - plugin.restart.duration is the time it took to restart crashed
+//     plugins, in seconds.
+type Recorder struct {
+	starts          metric.Int64Counter
+	exits           metric.Int64Counter
+	restarts        metric.Int64Counter
+	startDuration   metric.Float64Histogram
+	uptime          metric.Float64Histogram
+	restartDuration metric.Float64Histogram
+}
+
+// NewRecorder creates the instruments of a Recorder with a meter of mp.
+func NewRecorder(mp metric.MeterProvider) (*Recorder, error) {
+	m := mp.Meter(meterName)
+
+	var r Recorder
+	var err error
+	if r.starts, err = m.Int64Counter("plugin.starts",
+		metric.WithDescription("Number of plugins started.")); err != nil {
+		return nil, err
+	}
+	if r.exits, err = m.Int64Counter("plugin.exits",
+		metric.WithDescription("Number of plugin processes that exited.")); err != nil {
+		return nil, err
+	}
+	if r.restarts, err = m.Int64Counter("plugin.restarts",
+		metric.WithDescription("Number of crashed plugins restarted.")); err != nil {
+		return nil, err
+	}
+	if r.startDuration, err = m.Float64Histogram("plugin.start.duration",
+		metric.WithDescription("Time from launching a plugin to its handshake."),
+		metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if r.uptime, err = m.Float64Histogram("plugin.uptime",
+		metric.WithDescription("Time plugin processes ran before they exited."),
+		metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if r.restartDuration, err = m.Float64Histogram("plugin.restart.duration",
+		metric.WithDescription("Time it took to restart crashed plugins."),
+		metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// OnLifecycleEvent records e. It is meant to be used as
+// ClientConfig.OnLifecycleEvent.
+func (r *Recorder) OnLifecycleEvent(e plugin.LifecycleEvent) {
+	ctx := context.Background()
+	name := metric.WithAttributes(attribute.String("plugin", e.Plugin))
+
+	switch e.Kind {
+	case plugin.LifecycleStarted:
+		r.starts.Add(ctx, 1, name)
+		r.startDuration.Record(ctx, e.Duration.Seconds(), name)
+	case plugin.LifecycleExited:
+		r.exits.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("plugin", e.Plugin),
+			attribute.Bool("error", e.Err != nil)))
+		r.uptime.Record(ctx, e.Duration.Seconds(), name)
+	case plugin.LifecycleRestarted:
+		r.restarts.Add(ctx, 1, name)
+		r.restartDuration.Record(ctx, e.Duration.Seconds(), name)
+	}
+}
@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrPartialHandshake is returned by Start when the plugin wrote part of a
+// line to stdout but not the newline ending it within
+// ClientConfig.PartialHandshakeTimeout, which usually means it hung while
+// writing its handshake.
+var ErrPartialHandshake = errors.New("plugin wrote partial handshake")
+
+// maxPartialHandshake bounds how many bytes of a partial line are kept to
+// report in the error.
+const maxPartialHandshake = 512
+
+// partialLineReader records the bytes read since the last newline, so a line
+// the plugin never finished writing can be reported.
+type partialLineReader struct {
+	r io.Reader
+
+	m       sync.Mutex
+	partial []byte
+	since   time.Time
+}
+
+func (p *partialLineReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.record(b[:n])
+	}
+	return n, err
+}
+
+func (p *partialLineReader) record(b []byte) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+		p.partial = p.partial[:0]
+		b = b[i+1:]
+	}
+	if len(b) == 0 {
+		return
+	}
+	if len(p.partial) == 0 {
+		p.since = time.Now()
+	}
+	if room := maxPartialHandshake - len(p.partial); room > 0 {
+		if len(b) > room {
+			b = b[:room]
+		}
+		p.partial = append(p.partial, b...)
+	}
+}
+
+// stale returns the error describing the partial line if it has been
+// pending for longer than d.
+func (p *partialLineReader) stale(d time.Duration) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if len(p.partial) == 0 || time.Since(p.since) < d {
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrPartialHandshake, p.partial)
+}
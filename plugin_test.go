@@ -0,0 +1,384 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// testHandshake is the handshake of the test plugins.
+var testHandshake = HandshakeConfig{
+	MagicCookieKey:   "TEST_MAGIC_COOKIE",
+	MagicCookieValue: "test",
+}
+
+// testStaleAddr is the address in the stale handshake printed by the
+// "superseded" helper, which nothing listens on.
+const testStaleAddr = "127.0.0.1:1"
+
+// testGRPCPlugin is a plugin serving testServiceDesc, whose client is the
+// connection to the plugin.
+type testGRPCPlugin struct{}
+
+func (testGRPCPlugin) GRPCServer(_ *GRPCBroker, s *grpc.Server) error {
+	// The plugins of the multiplexing helpers may share a server.
+	if _, ok := s.GetServiceInfo()[testServiceName]; !ok {
+		s.RegisterService(&testServiceDesc, nil)
+	}
+	return nil
+}
+
+func (testGRPCPlugin) GRPCClient(_ context.Context, _ *GRPCBroker, conn *grpc.ClientConn) (interface{}, error) {
+	return conn, nil
+}
+
+// testServiceName is the service of testGRPCPlugin, whose methods let the
+// tests drive the plugin:
+//
+//   - Block blocks until the call is cancelled, and records the reason it
+//     was cancelled with.
+//   - CancelReason returns the reason recorded by the last Block.
+//   - SetBackpressure calls SetBackpressure with its argument.
+//   - Stream sends a message, then blocks until the call is cancelled.
+const testServiceName = "plugin.test.Test"
+
+// testCancelReason is the reason recorded by the last Block call.
+var testCancelReason atomic.Value
+
+var testServiceDesc = grpc.ServiceDesc{
+	ServiceName: testServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Block",
+			Handler: testUnaryHandler(new(emptypb.Empty), func(ctx context.Context, _ proto.Message) (proto.Message, error) {
+				<-ctx.Done()
+				reason, _ := CancelReasonFromContext(ctx)
+				testCancelReason.Store(string(reason))
+				return nil, ctx.Err()
+			}),
+		},
+		{
+			MethodName: "CancelReason",
+			Handler: testUnaryHandler(new(emptypb.Empty), func(context.Context, proto.Message) (proto.Message, error) {
+				reason, _ := testCancelReason.Load().(string)
+				return wrapperspb.String(reason), nil
+			}),
+		},
+		{
+			MethodName: "SetBackpressure",
+			Handler: testUnaryHandler(new(wrapperspb.BoolValue), func(_ context.Context, req proto.Message) (proto.Message, error) {
+				SetBackpressure(req.(*wrapperspb.BoolValue).Value)
+				return new(emptypb.Empty), nil
+			}),
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Stream",
+			ServerStreams: true,
+			Handler: func(_ interface{}, stream grpc.ServerStream) error {
+				if err := stream.RecvMsg(new(emptypb.Empty)); err != nil {
+					return err
+				}
+				if err := stream.SendMsg(new(emptypb.Empty)); err != nil {
+					return err
+				}
+				<-stream.Context().Done()
+				return stream.Context().Err()
+			},
+		},
+	},
+}
+
+// testUnaryHandler returns the handler of a unary method of testServiceDesc
+// taking req, served by fn through the interceptors of the server.
+func testUnaryHandler(req proto.Message, fn func(context.Context, proto.Message) (proto.Message, error)) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(_ interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := proto.Clone(req)
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return fn(ctx, req.(proto.Message))
+		}
+		if interceptor == nil {
+			return handler(ctx, req)
+		}
+		method, _ := grpc.Method(ctx)
+		return interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	}
+}
+
+// testCall calls method of testServiceDesc on conn.
+func testCall(ctx context.Context, conn *grpc.ClientConn, method string, req, resp proto.Message) error {
+	return conn.Invoke(ctx, "/"+testServiceName+"/"+method, req, resp)
+}
+
+// testDispense dispenses the connection to the test plugin of c.
+func testDispense(t *testing.T, c *Client) *grpc.ClientConn {
+	t.Helper()
+	client, err := c.Client()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	conn, err := client.Dispense("test")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return conn.(*grpc.ClientConn)
+}
+
+// testPluginSet is the plugin set served and consumed by the tests.
+var testPluginSet = PluginSet{"test": testGRPCPlugin{}}
+
+// testLogger discards what it logs, to keep the output of the tests and of
+// the plugins they launch readable.
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// helperProcess returns a command running the test binary as the plugin of
+// the given mode, see TestHelperProcess.
+func helperProcess(mode string, env ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$", "--", mode)
+	cmd.Env = append([]string{"PLUGIN_TEST_HELPER=1"}, env...)
+	return cmd
+}
+
+// testClientConfig returns the config of a client launching the plugin of the
+// given mode.
+func testClientConfig(mode string, env ...string) *ClientConfig {
+	return &ClientConfig{
+		HandshakeConfig:  testHandshake,
+		VersionedPlugins: map[int]PluginSet{1: testPluginSet},
+		Cmd:              helperProcess(mode, env...),
+		Logger:           testLogger(),
+		StartTimeout:     10 * time.Second,
+	}
+}
+
+// testStartClient starts a client of the plugin of the given mode, killed
+// when the test ends.
+func testStartClient(t *testing.T, config *ClientConfig) *Client {
+	t.Helper()
+	c := NewClient(config)
+	t.Cleanup(c.Kill)
+	if _, err := c.Start(); err != nil {
+		t.Fatalf("error starting plugin: %s", err)
+	}
+	return c
+}
+
+// failingListener is a listener whose Accept fails.
+type failingListener struct {
+	net.Listener
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	return nil, errors.New("accept failed")
+}
+
+// testMultiplexPluginSet is the plugin set of the multiplexing helpers.
+var testMultiplexPluginSet = PluginSet{"a": testGRPCPlugin{}, "b": testGRPCPlugin{}}
+
+// serveMultiplexed serves each group of plugins on its own listener, and
+// prints a handshake naming the plugin for each of the given names, the
+// way a consolidated plugin process does. With AutoMTLS, every listener
+// has a certificate of its own.
+func serveMultiplexed(groups ...[]string) {
+	for _, names := range groups {
+		addr, serverCert := serveTestPlugins(names)
+		for _, name := range names {
+			fmt.Printf("%d|1|tcp|%s|grpc|%s|%s|%s\n", CoreProtocolVersion, addr, serverCert, LibraryVersion, name)
+		}
+	}
+	select {}
+}
+
+// serveLegacy serves the test plugin and prints the five field handshake of
+// plugins built with earlier versions of this library, which have the
+// certificate in place of the protocol.
+func serveLegacy() {
+	addr, serverCert := serveTestPlugins([]string{"test"})
+	fmt.Printf("%d|1|tcp|%s|%s\n", CoreProtocolVersion, addr, serverCert)
+	select {}
+}
+
+// serveTestPlugins serves the named plugins on a listener of their own and
+// returns its address and handshake certificate.
+func serveTestPlugins(names []string) (net.Addr, string) {
+	plugins := make(map[string]Plugin)
+	for _, name := range names {
+		plugins[name] = testGRPCPlugin{}
+	}
+	tlsConfig, serverCert, err := testServerTLS()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	server := &GRPCServer{
+		Plugins: plugins,
+		Server:  DefaultGRPCServer,
+		TLS:     tlsConfig,
+		DoneCh:  make(chan struct{}),
+		Stdout:  new(bytes.Buffer),
+		Stderr:  new(bytes.Buffer),
+		logger:  testLogger(),
+	}
+	if err := server.Init(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	go server.Serve(l)
+	// The plugin exits once the host shut any of the servers down.
+	go func() {
+		<-server.DoneCh
+		os.Exit(0)
+	}()
+	return l.Addr(), serverCert
+}
+
+// testServerTLS returns the TLS config and handshake certificate of a
+// plugin server if the client uses AutoMTLS, the way Serve sets them up.
+func testServerTLS() (*tls.Config, string, error) {
+	clientCert := os.Getenv("PLUGIN_CLIENT_CERT")
+	if clientCert == "" {
+		return nil, "", nil
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM([]byte(clientCert))
+
+	certPEM, keyPEM, err := generateCert(nil)
+	if err != nil {
+		return nil, "", err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, "", err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+		RootCAs:      pool,
+		ServerName:   "localhost",
+	}, base64.RawStdEncoding.EncodeToString(cert.Certificate[0]), nil
+}
+
+// TestHelperProcess isn't a real test. It is the plugin launched by the
+// tests through helperProcess, serving the mode given after "--".
+func TestHelperProcess(*testing.T) {
+	if os.Getenv("PLUGIN_TEST_HELPER") != "1" {
+		return
+	}
+	defer os.Exit(0)
+
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "no helper mode given")
+		os.Exit(2)
+	}
+
+	config := &ServeConfig{
+		HandshakeConfig:  testHandshake,
+		VersionedPlugins: map[int]PluginSet{1: testPluginSet},
+		Logger:           slog.New(slog.NewTextHandler(os.Stderr, nil)),
+	}
+	switch mode := args[1]; mode {
+	case "test-grpc":
+	case "mac":
+		config.MessageMAC = true
+	case "overloaded":
+		// The plugin is overloaded until it recovers on its own.
+		SetBackpressure(true)
+		go func() {
+			time.Sleep(time.Second)
+			SetBackpressure(false)
+		}()
+	case "not-serving":
+		config.HealthServer = NewHealthServer(false)
+	case "becomes-serving":
+		// The plugin reports NOT_SERVING until it initialized.
+		health := NewHealthServer(false)
+		config.HealthServer = health
+		go func() {
+			time.Sleep(500 * time.Millisecond)
+			health.SetServing(true)
+		}()
+	case "serve-error":
+		// The server fails with the error of Accept right away.
+		config.ListenerFunc = func() (net.Listener, error) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			return &failingListener{Listener: l}, err
+		}
+	case "superseded":
+		// A stale handshake is printed before the one of the plugin.
+		fmt.Printf("%d|1|tcp|%s|grpc\n", CoreProtocolVersion, testStaleAddr)
+	case "legacy":
+		serveLegacy()
+	case "multiplex":
+		serveMultiplexed([]string{"a"}, []string{"b"})
+	case "multiplex-shared":
+		serveMultiplexed([]string{"a", "b"})
+	case "multiplex-missing":
+		serveMultiplexed([]string{"a"})
+	case "multiplex-unnamed":
+		serveMultiplexed([]string{""})
+	case "partial-handshake":
+		// The plugin hangs in the middle of its handshake.
+		fmt.Printf("%d|1|tcp", CoreProtocolVersion)
+		select {}
+	case "crash":
+		// The plugin crashes shortly after it started serving.
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			os.Exit(1)
+		}()
+	case "exit":
+		// The plugin dies before it serves.
+		os.Exit(3)
+	case "seccomp-exec":
+		// Once the seccomp profile is installed, executing anything fails.
+		if err := installSeccomp(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err := exec.Command(os.Args[0]).Run(); !errors.Is(err, syscall.EPERM) {
+			fmt.Fprintf(os.Stderr, "expected EPERM executing a command, got %v\n", err)
+			os.Exit(1)
+		}
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown helper mode %q\n", mode)
+		os.Exit(2)
+	}
+	Serve(config)
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package plugin
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detachFromSignals places the plugin in its own process group so that
+// signals sent to the host's process group, such as SIGINT from a terminal,
+// are not delivered to it.
+func detachFromSignals(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
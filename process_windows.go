@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+package plugin
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detachFromSignals starts the plugin in a new process group so that console
+// control events sent to the host, such as CTRL+C, are not delivered to it.
+func detachFromSignals(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+}
@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrProvenanceAndReattach is returned when both Reattach and Provenance are
+// set.
+var ErrProvenanceAndReattach = errors.New("only one of Reattach or Provenance can be set")
+
+// ProvenanceConfig is used to configure a client to verify a provenance
+// attestation of the plugin binary, such as an in-toto statement signed with
+// cosign, before running it. It is checked in addition to SecureConfig.
+type ProvenanceConfig struct {
+	// Attestation is the attestation of the binary, in the format the
+	// Verifier expects.
+	Attestation []byte
+
+	// Verifier verifies the attestation. It is responsible for checking
+	// its signature against the trusted builders.
+	Verifier ProvenanceVerifier
+}
+
+// ProvenanceSubject identifies the binary an attestation must be about.
+type ProvenanceSubject struct {
+	// Path is the path of the binary.
+	Path string
+
+	// Digest holds the digests of the binary by algorithm, as in in-toto
+	// subjects. The client sets "sha256".
+	Digest map[string]string
+}
+
+// ProvenanceVerifier verifies provenance attestations.
+type ProvenanceVerifier interface {
+	// Verify returns an error unless attestation is a valid attestation
+	// for subject.
+	Verify(ctx context.Context, attestation []byte, subject ProvenanceSubject) error
+}
+
+// ProvenanceVerifierFunc is a function that implements ProvenanceVerifier.
+type ProvenanceVerifierFunc func(ctx context.Context, attestation []byte, subject ProvenanceSubject) error
+
+func (f ProvenanceVerifierFunc) Verify(ctx context.Context, attestation []byte, subject ProvenanceSubject) error {
+	return f(ctx, attestation, subject)
+}
+
+// Check verifies the attestation for the binary at filePath.
+func (p *ProvenanceConfig) Check(ctx context.Context, filePath string) error {
+	if p.Verifier == nil {
+		return errors.New("no provenance verifier provided")
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return err
+	}
+
+	return p.Verifier.Verify(ctx, p.Attestation, ProvenanceSubject{
+		Path:   filePath,
+		Digest: map[string]string{"sha256": hex.EncodeToString(h.Sum(nil))},
+	})
+}
+
+// VerifyInTotoSubject checks that attestation is an in-toto statement, bare
+// or wrapped in a DSSE envelope, that lists a subject with a digest of
+// subject. It does not verify any signature, and is meant to be used by
+// ProvenanceVerifier implementations once they verified the envelope.
+func VerifyInTotoSubject(attestation []byte, subject ProvenanceSubject) error {
+	var envelope struct {
+		PayloadType string `json:"payloadType"`
+		Payload     string `json:"payload"`
+	}
+	if err := json.Unmarshal(attestation, &envelope); err != nil {
+		return fmt.Errorf("invalid attestation: %w", err)
+	}
+	statement := attestation
+	if envelope.PayloadType != "" {
+		payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+		if err != nil {
+			return fmt.Errorf("invalid attestation payload: %w", err)
+		}
+		statement = payload
+	}
+
+	var st struct {
+		Subject []struct {
+			Digest map[string]string `json:"digest"`
+		} `json:"subject"`
+	}
+	if err := json.Unmarshal(statement, &st); err != nil {
+		return fmt.Errorf("invalid in-toto statement: %w", err)
+	}
+	for _, s := range st.Subject {
+		for alg, digest := range subject.Digest {
+			if s.Digest[alg] == digest {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("attestation is not about %s", subject.Path)
+}
@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrResourceLimitsUnsupported is returned by Client.SetResourceLimits on
+// hosts without cgroup v2.
+var ErrResourceLimitsUnsupported = errors.New("resource limits require cgroup v2")
+
+// ErrPluginCgroupShared is returned by Client.SetResourceLimits when the
+// plugin runs in the cgroup of the host, whose limits would change too. The
+// plugin must be placed in a cgroup of its own, e.g. by a RunnerFunc.
+var ErrPluginCgroupShared = errors.New("plugin shares the cgroup of the host")
+
+// ResourceLimits are the limits applied to a running plugin by
+// Client.SetResourceLimits. Zero fields leave the current limit unchanged,
+// and negative ones remove it.
+type ResourceLimits struct {
+	// MemoryMax is the memory limit in bytes (memory.max).
+	MemoryMax int64
+
+	// CPUQuota is the CPU time the plugin may use per CPUPeriod (cpu.max).
+	CPUQuota time.Duration
+
+	// CPUPeriod is the period of CPUQuota. Defaults to 100ms.
+	CPUPeriod time.Duration
+
+	// PidsMax is the maximum number of processes and threads (pids.max).
+	PidsMax int64
+}
+
+// SetResourceLimits adjusts the cgroup limits of the running plugin without
+// restarting it, e.g. to throttle a misbehaving plugin instead of killing
+// it. It is only supported on Linux with cgroup v2, for plugins that run in
+// a cgroup of their own.
+func (c *Client) SetResourceLimits(ctx context.Context, limits ResourceLimits) error {
+	c.m.Lock()
+	r := c.runner
+	exited := c.exited
+	c.m.Unlock()
+
+	if r == nil || exited {
+		return errors.New("plugin is not running")
+	}
+	pid, err := strconv.Atoi(r.ID())
+	if err != nil {
+		return fmt.Errorf("plugin id %q is not a pid", r.ID())
+	}
+	return setCgroupLimits(ctx, pid, limits)
+}
+
+// cgroupLimitFiles returns the cgroup interface files and values that apply
+// limits, in the order they are written.
+func cgroupLimitFiles(limits ResourceLimits) [][2]string {
+	var files [][2]string
+	if v, ok := cgroupLimitValue(limits.MemoryMax); ok {
+		files = append(files, [2]string{"memory.max", v})
+	}
+	if limits.CPUQuota != 0 {
+		period := limits.CPUPeriod
+		if period <= 0 {
+			period = 100 * time.Millisecond
+		}
+		quota := "max"
+		if limits.CPUQuota > 0 {
+			quota = strconv.FormatInt(limits.CPUQuota.Microseconds(), 10)
+		}
+		files = append(files, [2]string{"cpu.max", quota + " " + strconv.FormatInt(period.Microseconds(), 10)})
+	}
+	if v, ok := cgroupLimitValue(limits.PidsMax); ok {
+		files = append(files, [2]string{"pids.max", v})
+	}
+	return files
+}
+
+// cgroupLimitValue returns the cgroup value of a limit, and false if it is
+// left unchanged.
+func cgroupLimitValue(v int64) (string, bool) {
+	switch {
+	case v == 0:
+		return "", false
+	case v < 0:
+		return "max", true
+	}
+	return strconv.FormatInt(v, 10), true
+}
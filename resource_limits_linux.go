@@ -0,0 +1,67 @@
+//go:build linux
+// +build linux
+
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is the mount point of the cgroup v2 hierarchy.
+const cgroupRoot = "/sys/fs/cgroup"
+
+func setCgroupLimits(ctx context.Context, pid int, limits ResourceLimits) error {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return ErrResourceLimitsUnsupported
+	}
+
+	group, err := cgroupOf(strconv.Itoa(pid))
+	if err != nil {
+		return err
+	}
+	host, err := cgroupOf("self")
+	if err != nil {
+		return err
+	}
+	if group == host || group == "/" {
+		return ErrPluginCgroupShared
+	}
+
+	dir := filepath.Join(cgroupRoot, group)
+	for _, f := range cgroupLimitFiles(limits) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, f[0]), []byte(f[1]), 0); err != nil {
+			return fmt.Errorf("error setting %s: %w", f[0], err)
+		}
+	}
+	return nil
+}
+
+// cgroupOf returns the cgroup v2 path of the process, relative to the root
+// of the hierarchy.
+func cgroupOf(pid string) (string, error) {
+	f, err := os.Open(filepath.Join("/proc", pid, "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if path, ok := strings.CutPrefix(s.Text(), "0::"); ok {
+			return path, nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", ErrResourceLimitsUnsupported
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package plugin
+
+import (
+	"context"
+)
+
+func setCgroupLimits(context.Context, int, ResourceLimits) error {
+	return ErrResourceLimitsUnsupported
+}
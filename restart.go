@@ -0,0 +1,157 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// GracefulRestart replaces the plugin with a freshly launched instance of the
+// same command. It starts the new plugin, waits until it reports healthy,
+// then gracefully shuts down the plugin managed by c, as Kill does, and
+// returns the ReattachConfig of the new plugin.
+//
+// The new plugin is launched with a copy of c's configuration. After a
+// successful restart, c has been killed and must not be used to talk to the
+// plugin anymore; attach to the new plugin through the returned
+// ReattachConfig instead. If c is managed, the new plugin is managed too and
+// is killed by CleanupClients.
+//
+// If ctx is cancelled before the new plugin is healthy, the new plugin is
+// killed and the old one is left running.
+//
+// Since both plugins run at the same time, GracefulRestart returns an error
+// for configurations that allow only one instance at a time: a FixedPort, a
+// UnixSocketConfig.FixedName or Singleton. Restart those by killing the
+// plugin and starting a new client instead. Plugins connected to through
+// Reattach or Discovery weren't launched by c, and can't be restarted.
+func (c *Client) GracefulRestart(ctx context.Context) (*ReattachConfig, error) {
+	c.m.Lock()
+	if c.config.Reattach != nil {
+		c.m.Unlock()
+		return nil, errors.New("cannot restart a reattached plugin")
+	}
+	if err := exclusiveInstance(c.config); err != nil {
+		c.m.Unlock()
+		return nil, fmt.Errorf("cannot gracefully restart plugin: %w", err)
+	}
+	if c.config.Discovery != nil {
+		c.m.Unlock()
+		return nil, errors.New("cannot restart a discovered plugin")
+	}
+	if c.address == nil {
+		c.m.Unlock()
+		return nil, errors.New("cannot restart a plugin that was not started")
+	}
+	config := *c.config
+	// With RunnerFunc, the template is only the placeholder the runner is
+	// given, so the new plugin is launched by RunnerFunc again.
+	if c.config.Cmd != nil {
+		config.Cmd = cloneCmd(c.cmdTemplate)
+	}
+	c.m.Unlock()
+
+	next := NewClient(&config)
+	if _, err := next.Start(); err != nil {
+		next.Kill()
+		return nil, fmt.Errorf("error starting new plugin: %w", err)
+	}
+	if err := next.waitPing(ctx); err != nil {
+		next.Kill()
+		return nil, fmt.Errorf("new plugin did not become healthy: %w", err)
+	}
+
+	c.Kill()
+
+	next.m.Lock()
+	rc := next.reattachConfig()
+	next.m.Unlock()
+	if rc == nil {
+		next.Kill()
+		return nil, errors.New("new plugin exited during the restart")
+	}
+	return rc, nil
+}
+
+// exclusiveInstance returns an error if config allows only one instance of
+// the plugin to run at a time, because the new instance could not bind its
+// address or take its lock while the old one holds it.
+func exclusiveInstance(config *ClientConfig) error {
+	switch {
+	case config.FixedPort != 0:
+		return errors.New("FixedPort is set")
+	case config.UnixSocketConfig != nil && config.UnixSocketConfig.FixedName != "":
+		return errors.New("UnixSocketConfig.FixedName is set")
+	case config.Singleton:
+		return errors.New("Singleton is set")
+	}
+	return nil
+}
+
+// waitPing dials the plugin and pings it until it responds or ctx is done,
+// backing off between attempts.
+func (c *Client) waitPing(ctx context.Context) error {
+	return retryWithBackoff(ctx, func() error {
+		client, err := c.protocolClient()
+		if err != nil {
+			return err
+		}
+		return client.Ping()
+	})
+}
+
+// ReattachConfig returns the information needed to reattach to the running
+// plugin from another process, e.g. after serializing it to disk. It returns
+// nil if the plugin hasn't been started or has exited.
+func (c *Client) ReattachConfig() *ReattachConfig {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.reattachConfig()
+}
+
+// reattachConfig returns the configuration to reattach to the running plugin,
+// or nil if it isn't running. The caller must hold c.m.
+func (c *Client) reattachConfig() *ReattachConfig {
+	if c.address == nil || c.exited {
+		return nil
+	}
+
+	// A client that reattached may not own the process, e.g. in test mode.
+	if c.config.Reattach != nil {
+		rc := *c.config.Reattach
+		return &rc
+	}
+
+	if c.runner == nil {
+		return nil
+	}
+
+	pid, _ := strconv.Atoi(c.runner.ID())
+	return &ReattachConfig{
+		ProtocolVersion: c.negotiatedVersion,
+		Addr:            c.address,
+		Pid:             pid,
+	}
+}
+
+// cloneCmd returns an unstarted copy of cmd.
+func cloneCmd(cmd *exec.Cmd) *exec.Cmd {
+	clone := &exec.Cmd{
+		Path:       cmd.Path,
+		Args:       append([]string(nil), cmd.Args...),
+		Env:        append([]string(nil), cmd.Env...),
+		Dir:        cmd.Dir,
+		ExtraFiles: cmd.ExtraFiles,
+		Stdout:     cmd.Stdout,
+		Stderr:     cmd.Stderr,
+		Stdin:      cmd.Stdin,
+	}
+	if cmd.SysProcAttr != nil {
+		attr := *cmd.SysProcAttr
+		clone.SysProcAttr = &attr
+	}
+	return clone
+}
@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/kform-dev/plugin/cmdrunner"
+	"github.com/kform-dev/plugin/runner"
+)
+
+func TestClientGracefulRestart(t *testing.T) {
+	cases := map[string]func() *ClientConfig{
+		"cmd": func() *ClientConfig {
+			return testClientConfig("test-grpc")
+		},
+		"runner func": func() *ClientConfig {
+			config := testClientConfig("test-grpc")
+			template := config.Cmd
+			config.Cmd = nil
+			config.RunnerFunc = func(l *slog.Logger, cmd *exec.Cmd, _ string) (runner.Runner, error) {
+				cmd.Path = template.Path
+				cmd.Args = template.Args
+				cmd.Env = append(cmd.Env, template.Env...)
+				return cmdrunner.NewCmdRunner(l, cmd)
+			}
+			return config
+		},
+	}
+	for name, config := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := testStartClient(t, config())
+			old := c.ReattachConfig()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			rc, err := c.GracefulRestart(ctx)
+			if err != nil {
+				t.Fatalf("error restarting plugin: %s", err)
+			}
+			if rc == nil || rc.Pid == old.Pid {
+				t.Fatalf("restart returned %+v, want a new plugin than %+v", rc, old)
+			}
+			if !c.Exited() {
+				t.Fatal("old plugin was not killed")
+			}
+
+			next := NewClient(&ClientConfig{
+				HandshakeConfig:  testHandshake,
+				VersionedPlugins: map[int]PluginSet{1: testPluginSet},
+				Reattach:         rc,
+				Logger:           testLogger(),
+			})
+			defer next.Kill()
+			cp, err := next.Client()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cp.Ping(); err != nil {
+				t.Fatalf("new plugin is not reachable: %s", err)
+			}
+		})
+	}
+}
+
+func TestClientGracefulRestart_unsupported(t *testing.T) {
+	cases := map[string]*ClientConfig{
+		"fixed port": {FixedPort: 31337},
+		"singleton":  {Singleton: true},
+		"discovery":  {Discovery: &DiscoveryConfig{}},
+	}
+	for name, config := range cases {
+		t.Run(name, func(t *testing.T) {
+			config.Logger = testLogger()
+			c := NewClient(config)
+			c.address = &net.TCPAddr{}
+			rc, err := c.GracefulRestart(context.Background())
+			if err == nil || rc != nil {
+				t.Fatalf("restart returned %v, %v, want an error", rc, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// RetryBudget caps the retries of the calls to the plugin across all calls,
+// so that retry policies enabled with ClientConfig.ServiceConfigJSON don't
+// amplify the load on a plugin that is failing. It implements the retry
+// throttling of gRPC: the budget starts with MaxTokens tokens, each failed
+// call takes one, each successful call gives back TokenRatio, and calls are
+// only retried while more than half of the tokens are left. See
+// https://github.com/grpc/proposal/blob/master/A6-client-retries.md#throttling-retry-attempts-and-hedged-rpcs.
+type RetryBudget struct {
+	// MaxTokens is the size of the budget, between 1 and 1000.
+	MaxTokens int
+
+	// TokenRatio is the number of tokens a successful call refills, with
+	// up to three decimals.
+	TokenRatio float64
+}
+
+func (b *RetryBudget) validate() error {
+	if b.MaxTokens <= 0 || b.MaxTokens > 1000 {
+		return fmt.Errorf("invalid RetryBudget: MaxTokens must be between 1 and 1000, got %d", b.MaxTokens)
+	}
+	if b.TokenRatio <= 0 {
+		return errors.New("invalid RetryBudget: TokenRatio must be positive")
+	}
+	return nil
+}
+
+// serviceConfigWithRetryBudget returns the service config js, which may be
+// empty, with its retry throttling set from b.
+func serviceConfigWithRetryBudget(js string, b *RetryBudget) (string, error) {
+	if err := b.validate(); err != nil {
+		return "", err
+	}
+
+	sc := map[string]interface{}{}
+	if js != "" {
+		if err := json.Unmarshal([]byte(js), &sc); err != nil {
+			return "", fmt.Errorf("invalid ServiceConfigJSON: %w", err)
+		}
+	}
+	if _, ok := sc["retryThrottling"]; ok {
+		return "", errors.New("only one of RetryBudget or the retryThrottling of ServiceConfigJSON can be set")
+	}
+	sc["retryThrottling"] = map[string]interface{}{
+		"maxTokens":  b.MaxTokens,
+		"tokenRatio": b.TokenRatio,
+	}
+
+	out, err := json.Marshal(sc)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
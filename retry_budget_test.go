@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestServiceConfigWithRetryBudget(t *testing.T) {
+	budget := &RetryBudget{MaxTokens: 10, TokenRatio: 0.1}
+	methodConfig := `{"methodConfig":[{"name":[{}],"retryPolicy":{"maxAttempts":3}}]}`
+
+	cases := []struct {
+		name   string
+		js     string
+		budget *RetryBudget
+		want   string
+		err    bool
+	}{
+		{"empty", "", budget, `{"retryThrottling":{"maxTokens":10,"tokenRatio":0.1}}`, false},
+		{"merged", methodConfig, budget,
+			`{"methodConfig":[{"name":[{}],"retryPolicy":{"maxAttempts":3}}],"retryThrottling":{"maxTokens":10,"tokenRatio":0.1}}`, false},
+		{"conflict", `{"retryThrottling":{"maxTokens":1,"tokenRatio":1}}`, budget, "", true},
+		{"invalid json", "{", budget, "", true},
+		{"no tokens", "", &RetryBudget{TokenRatio: 0.1}, "", true},
+		{"too many tokens", "", &RetryBudget{MaxTokens: 1001, TokenRatio: 0.1}, "", true},
+		{"no ratio", "", &RetryBudget{MaxTokens: 10}, "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := serviceConfigWithRetryBudget(tc.js, tc.budget)
+			if (err != nil) != tc.err {
+				t.Fatalf("err %v, want error %t", err, tc.err)
+			}
+			if tc.err {
+				return
+			}
+
+			var gotSC, wantSC map[string]interface{}
+			if err := json.Unmarshal([]byte(got), &gotSC); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if err := json.Unmarshal([]byte(tc.want), &wantSC); err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if !reflect.DeepEqual(gotSC, wantSC) {
+				t.Fatalf("service config %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClientRetryBudget(t *testing.T) {
+	config := testClientConfig("test-grpc")
+	config.ServiceConfigJSON = `{"methodConfig":[{"name":[{}],"retryPolicy":{"maxAttempts":3,"initialBackoff":"0.1s","maxBackoff":"1s","backoffMultiplier":2,"retryableStatusCodes":["UNAVAILABLE"]}}]}`
+	config.RetryBudget = &RetryBudget{MaxTokens: 10, TokenRatio: 0.1}
+	c := testStartClient(t, config)
+
+	client, err := c.Client()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := client.Ping(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
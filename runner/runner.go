@@ -6,6 +6,7 @@ package runner
 import (
 	"context"
 	"io"
+	"os"
 )
 
 // Runner defines the interface required by go-plugin to manage the lifecycle of
@@ -23,10 +24,12 @@ type Runner interface {
 	// connection.
 	Diagnose(ctx context.Context) string
 
-	// Stdout is used to negotiate the go-plugin protocol.
+	// Stdout is used to negotiate the go-plugin protocol. It may return nil
+	// if the runner implements ReadinessReporter.
 	Stdout() io.ReadCloser
 
 	// Stderr is used for forwarding plugin logs to the host process logger.
+	// It may return nil, in which case the plugin logs are not forwarded.
 	Stderr() io.ReadCloser
 
 	// Name is a human-friendly name for the plugin, such as the path to the
@@ -36,6 +39,30 @@ type Runner interface {
 	AttachedRunner
 }
 
+// Signaler is implemented by runners that can send a signal to the plugin.
+type Signaler interface {
+	// Signal sends sig to the plugin.
+	Signal(sig os.Signal) error
+}
+
+// ReadinessReporter is implemented by runners that can't provide the plugin's
+// stdout, e.g. container runners that stream output differently. Their Stdout
+// method returns nil, and the client obtains the handshake from Ready instead.
+type ReadinessReporter interface {
+	// Ready blocks until the plugin is ready to accept connections and
+	// returns the handshake line the plugin printed. If the context is
+	// cancelled, it should return with the context's error.
+	Ready(ctx context.Context) (string, error)
+}
+
+// CommandReporter is implemented by runners that don't execute the command
+// they were given as is, e.g. because they wrap it or run it elsewhere, so
+// the client can report what actually runs the plugin.
+type CommandReporter interface {
+	// Command returns the argv and environment the plugin is run with.
+	Command() (args []string, env []string)
+}
+
 // AttachedRunner defines a limited subset of Runner's interface to represent the
 // reduced responsibility for plugin lifecycle when attaching to an already running
 // plugin.
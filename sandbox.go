@@ -0,0 +1,54 @@
+package plugin
+
+import (
+	"errors"
+)
+
+// ErrSandboxUnsupported is returned by Start when ClientConfig.Sandbox is set
+// on a platform other than Linux.
+var ErrSandboxUnsupported = errors.New("plugin sandboxing is only supported on linux")
+
+// SandboxConfig configures the isolation applied to the plugin subprocess.
+// Sandboxing is only supported on Linux; Start returns ErrSandboxUnsupported
+// elsewhere. It can not be used with Reattach.
+//
+// The namespaces and chroot are applied through exec.Cmd.SysProcAttr. The Go
+// runtime can't run code between fork and exec, so the seccomp profile is
+// installed by the plugin itself, in Serve. Read-only remounts and custom
+// seccomp profiles must be applied by a launcher given in Wrapper.
+type SandboxConfig struct {
+	// Namespaces is the set of syscall.CLONE_NEW* flags the plugin is
+	// started with. If zero, DefaultSandboxNamespaces is used.
+	//
+	// A new user namespace is required for unprivileged hosts, and maps the
+	// host's uid and gid to the same ids inside the namespace. A new network
+	// namespace leaves the plugin without network access; Unix sockets on
+	// the filesystem still work, so the plugin must not be forced to TCP.
+	Namespaces uintptr
+
+	// Chroot, if set, is the directory the plugin is chrooted into. The
+	// plugin binary path is resolved inside it, so SecureConfig and
+	// Provenance are checked against the binary at the plugin path within
+	// Chroot. The plugin creates its Unix socket in the temporary directory
+	// inside Chroot, which must exist, and the client dials it there. The
+	// socket directories the client creates itself, for RunnerFunc and
+	// UnixSocketConfig.FixedName, are outside of it, so those can only be
+	// used with Chroot together with ForceTCP.
+	Chroot string
+
+	// Seccomp, if true, has the plugin install a default-deny seccomp
+	// profile before it serves. The profile allows the syscalls the Go
+	// runtime and a gRPC server need, and fails every other one, such as
+	// execve, ptrace and mount, with EPERM. It is supported on amd64 and
+	// arm64, and the plugin must be built with a version of this package
+	// supporting it, otherwise it runs without the profile. A plugin that
+	// can't install it fails to start.
+	Seccomp bool
+
+	// Wrapper is a command the plugin is executed through, for example a
+	// launcher that installs a seccomp profile or mounts a read-only root
+	// before executing its arguments. The plugin path and arguments are
+	// appended to it. SecureConfig is checked against the plugin binary, not
+	// the wrapper.
+	Wrapper []string
+}
@@ -0,0 +1,63 @@
+//go:build linux
+// +build linux
+
+package plugin
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// DefaultSandboxNamespaces is the set of namespaces used when
+// SandboxConfig.Namespaces is zero: the plugin gets its own user, mount, pid,
+// ipc, uts and network namespaces.
+const DefaultSandboxNamespaces = syscall.CLONE_NEWUSER |
+	syscall.CLONE_NEWNS |
+	syscall.CLONE_NEWPID |
+	syscall.CLONE_NEWIPC |
+	syscall.CLONE_NEWUTS |
+	syscall.CLONE_NEWNET
+
+// applySandbox configures cmd to run in the sandbox described by cfg.
+func applySandbox(cmd *exec.Cmd, cfg *SandboxConfig) error {
+	if len(cfg.Wrapper) > 0 {
+		path, err := exec.LookPath(cfg.Wrapper[0])
+		if err != nil {
+			return err
+		}
+		args := append([]string(nil), cfg.Wrapper...)
+		args = append(args, cmd.Path)
+		if len(cmd.Args) > 1 {
+			args = append(args, cmd.Args[1:]...)
+		}
+		cmd.Path = path
+		cmd.Args = args
+	}
+
+	namespaces := cfg.Namespaces
+	if namespaces == 0 {
+		namespaces = DefaultSandboxNamespaces
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= namespaces
+	cmd.SysProcAttr.Chroot = cfg.Chroot
+
+	if cfg.Seccomp {
+		cmd.Env = append(cmd.Env, EnvSandboxSeccomp+"=true")
+	}
+
+	if namespaces&syscall.CLONE_NEWUSER != 0 {
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{
+			{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1},
+		}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{
+			{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1},
+		}
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = false
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package plugin
+
+import (
+	"os/exec"
+)
+
+func applySandbox(_ *exec.Cmd, _ *SandboxConfig) error {
+	return ErrSandboxUnsupported
+}
@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/kform-dev/plugin/runner"
+)
+
+func testSkipSeccomp(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS != "linux" || (runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64") {
+		t.Skip("the seccomp profile is only supported on linux/amd64 and linux/arm64")
+	}
+}
+
+func TestServe_seccomp(t *testing.T) {
+	testSkipSeccomp(t)
+
+	c := testStartClient(t, testClientConfig("test-grpc", EnvSandboxSeccomp+"=true"))
+	client, err := c.Client()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := client.Ping(); err != nil {
+		t.Fatalf("ping under the seccomp profile: %s", err)
+	}
+
+	status, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", c.ReattachConfig().Pid))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(string(status), "Seccomp:\t2") {
+		t.Fatal("the plugin runs without a seccomp filter")
+	}
+}
+
+func TestInstallSeccomp_denyExec(t *testing.T) {
+	testSkipSeccomp(t)
+
+	out, err := helperProcess("seccomp-exec").CombinedOutput()
+	if err != nil {
+		t.Fatalf("err: %s\n%s", err, out)
+	}
+}
+
+func TestClientStart_chroot(t *testing.T) {
+	cases := []struct {
+		name   string
+		config func(*ClientConfig)
+	}{
+		{"runner func", func(c *ClientConfig) {
+			c.RunnerFunc = func(*slog.Logger, *exec.Cmd, string) (runner.Runner, error) {
+				t.Fatal("RunnerFunc called")
+				return nil, nil
+			}
+		}},
+		{"fixed name", func(c *ClientConfig) {
+			c.Cmd = helperProcess("test-grpc")
+			c.UnixSocketConfig = &UnixSocketConfig{FixedName: "test.sock"}
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &ClientConfig{
+				HandshakeConfig:  testHandshake,
+				VersionedPlugins: map[int]PluginSet{1: testPluginSet},
+				Sandbox:          &SandboxConfig{Chroot: t.TempDir()},
+				Logger:           testLogger(),
+			}
+			tc.config(config)
+
+			c := NewClient(config)
+			defer c.Kill()
+			if _, err := c.Start(); err == nil {
+				t.Fatal("expected an error for a chroot the socket directory is outside of")
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package plugin
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// seccompSyscalls is the allowlist of the default seccomp profile, on top of
+// the architecture specific seccompArchSyscalls. It covers what the Go
+// runtime and a gRPC server need: memory, threads, signals, files, sockets
+// and time. Everything else, most notably execve, ptrace, mount, the
+// namespace and module syscalls, fails with EPERM.
+var seccompSyscalls = []uintptr{
+	// memory
+	unix.SYS_BRK, unix.SYS_MMAP, unix.SYS_MUNMAP, unix.SYS_MPROTECT,
+	unix.SYS_MREMAP, unix.SYS_MADVISE, unix.SYS_MINCORE, unix.SYS_MEMBARRIER,
+
+	// threads and processes
+	unix.SYS_CLONE, unix.SYS_CLONE3, unix.SYS_FUTEX, unix.SYS_GETTID,
+	unix.SYS_GETPID, unix.SYS_GETPPID, unix.SYS_SET_TID_ADDRESS,
+	unix.SYS_SET_ROBUST_LIST, unix.SYS_GET_ROBUST_LIST, unix.SYS_RSEQ,
+	unix.SYS_SCHED_YIELD, unix.SYS_SCHED_GETAFFINITY, unix.SYS_EXIT,
+	unix.SYS_EXIT_GROUP, unix.SYS_WAIT4, unix.SYS_WAITID, unix.SYS_PRLIMIT64,
+	unix.SYS_GETRLIMIT, unix.SYS_GETRUSAGE, unix.SYS_PRCTL, unix.SYS_UNAME,
+	unix.SYS_SYSINFO, unix.SYS_GETUID, unix.SYS_GETEUID, unix.SYS_GETGID,
+	unix.SYS_GETEGID, unix.SYS_GETGROUPS, unix.SYS_CAPGET,
+
+	// signals
+	unix.SYS_RT_SIGACTION, unix.SYS_RT_SIGPROCMASK, unix.SYS_RT_SIGRETURN,
+	unix.SYS_SIGALTSTACK, unix.SYS_KILL, unix.SYS_TGKILL, unix.SYS_TKILL,
+	unix.SYS_RESTART_SYSCALL,
+
+	// files
+	unix.SYS_READ, unix.SYS_WRITE, unix.SYS_READV, unix.SYS_WRITEV,
+	unix.SYS_PREAD64, unix.SYS_PWRITE64, unix.SYS_OPENAT, unix.SYS_CLOSE,
+	unix.SYS_LSEEK, unix.SYS_FSTAT, unix.SYS_STATX, unix.SYS_FSTATFS,
+	unix.SYS_STATFS, unix.SYS_FCNTL, unix.SYS_IOCTL, unix.SYS_FLOCK,
+	unix.SYS_FSYNC, unix.SYS_FDATASYNC, unix.SYS_FTRUNCATE,
+	unix.SYS_GETDENTS64, unix.SYS_GETCWD, unix.SYS_READLINKAT,
+	unix.SYS_FACCESSAT, unix.SYS_FACCESSAT2, unix.SYS_MKDIRAT,
+	unix.SYS_UNLINKAT, unix.SYS_RENAMEAT, unix.SYS_RENAMEAT2,
+	unix.SYS_FCHMOD, unix.SYS_FCHMODAT, unix.SYS_FCHOWN, unix.SYS_FCHOWNAT,
+	unix.SYS_UTIMENSAT, unix.SYS_UMASK, unix.SYS_DUP, unix.SYS_DUP3,
+	unix.SYS_PIPE2, unix.SYS_EVENTFD2, unix.SYS_GETRANDOM,
+
+	// polling
+	unix.SYS_EPOLL_CREATE1, unix.SYS_EPOLL_CTL, unix.SYS_EPOLL_PWAIT,
+	unix.SYS_EPOLL_PWAIT2, unix.SYS_PPOLL, unix.SYS_PSELECT6,
+
+	// sockets
+	unix.SYS_SOCKET, unix.SYS_SOCKETPAIR, unix.SYS_BIND, unix.SYS_LISTEN,
+	unix.SYS_ACCEPT, unix.SYS_ACCEPT4, unix.SYS_CONNECT, unix.SYS_SHUTDOWN,
+	unix.SYS_GETSOCKNAME, unix.SYS_GETPEERNAME, unix.SYS_SETSOCKOPT,
+	unix.SYS_GETSOCKOPT, unix.SYS_SENDTO, unix.SYS_RECVFROM,
+	unix.SYS_SENDMSG, unix.SYS_RECVMSG,
+
+	// time
+	unix.SYS_CLOCK_GETTIME, unix.SYS_CLOCK_GETRES, unix.SYS_CLOCK_NANOSLEEP,
+	unix.SYS_GETTIMEOFDAY, unix.SYS_NANOSLEEP, unix.SYS_SETITIMER,
+	unix.SYS_TIMER_CREATE, unix.SYS_TIMER_SETTIME, unix.SYS_TIMER_DELETE,
+	unix.SYS_TIMERFD_CREATE, unix.SYS_TIMERFD_SETTIME,
+}
+
+// installSeccomp installs the default seccomp profile on every thread of the
+// process, after which the process and its children can't gain privileges.
+func installSeccomp() error {
+	prog := seccompFilter(append(seccompSyscalls, seccompArchSyscalls...))
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("error setting no_new_privs: %w", err)
+	}
+	_, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER,
+		unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(prog)))
+	if errno != 0 {
+		return fmt.Errorf("error installing seccomp profile: %w", errno)
+	}
+	return nil
+}
+
+// seccompFilter returns the BPF program allowing the given syscalls of the
+// native architecture, and failing every other one with EPERM.
+func seccompFilter(allowed []uintptr) *unix.SockFprog {
+	const (
+		archOffset = 4 // offsetof(struct seccomp_data, arch)
+		nrOffset   = 0 // offsetof(struct seccomp_data, nr)
+		deny       = unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)
+	)
+
+	n := len(allowed)
+	filter := []unix.SockFilter{
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: archOffset},
+		// Syscalls of the 32 bit ABIs have other numbers, so a mismatching
+		// architecture is denied before the numbers are compared.
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: seccompAuditArch, Jt: 1},
+		{Code: unix.BPF_RET | unix.BPF_K, K: deny},
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: nrOffset},
+	}
+	for i, nr := range allowed {
+		// Jump to the allow at the end of the program, or fall through to
+		// the next comparison.
+		filter = append(filter, unix.SockFilter{
+			Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K,
+			K:    uint32(nr),
+			Jt:   uint8(n - i),
+		})
+	}
+	filter = append(filter,
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: deny},
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: unix.SECCOMP_RET_ALLOW},
+	)
+
+	return &unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+}
@@ -0,0 +1,17 @@
+package plugin
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const seccompAuditArch = unix.AUDIT_ARCH_X86_64
+
+// seccompArchSyscalls are the legacy syscalls only amd64 has, which the Go
+// runtime and standard library still use there.
+var seccompArchSyscalls = []uintptr{
+	unix.SYS_ARCH_PRCTL, unix.SYS_OPEN, unix.SYS_STAT, unix.SYS_LSTAT,
+	unix.SYS_NEWFSTATAT, unix.SYS_ACCESS, unix.SYS_READLINK, unix.SYS_PIPE,
+	unix.SYS_DUP2, unix.SYS_POLL, unix.SYS_SELECT, unix.SYS_EPOLL_CREATE,
+	unix.SYS_EPOLL_WAIT, unix.SYS_GETDENTS, unix.SYS_MKDIR, unix.SYS_RMDIR,
+	unix.SYS_UNLINK, unix.SYS_RENAME, unix.SYS_CHMOD, unix.SYS_TIME,
+}
@@ -0,0 +1,12 @@
+package plugin
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const seccompAuditArch = unix.AUDIT_ARCH_AARCH64
+
+// seccompArchSyscalls are the syscalls arm64 names differently.
+var seccompArchSyscalls = []uintptr{
+	unix.SYS_FSTATAT,
+}
@@ -0,0 +1,12 @@
+//go:build !linux || !(amd64 || arm64)
+// +build !linux !amd64,!arm64
+
+package plugin
+
+import (
+	"errors"
+)
+
+func installSeccomp() error {
+	return errors.New("the seccomp profile is only supported on linux/amd64 and linux/arm64")
+}
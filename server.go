@@ -12,13 +12,16 @@ import (
 	"os"
 	"os/signal"
 	"os/user"
+	"reflect"
 	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/henderiw/logger/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 )
 
 // CoreProtocolVersion is the ProtocolVersion of the plugin system itself.
@@ -47,6 +50,50 @@ type HandshakeConfig struct {
 // server.
 type PluginSet map[string]Plugin
 
+// Validate checks that every entry in the set has a name and a usable Plugin
+// implementation, and returns an error naming each invalid entry. Nil plugins,
+// including typed nil pointers, would otherwise only fail once GRPCServer or
+// GRPCClient is called on them.
+func (s PluginSet) Validate() error {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		p := s[name]
+		switch {
+		case name == "":
+			errs = append(errs, errors.New("plugin with empty name"))
+		case p == nil:
+			errs = append(errs, fmt.Errorf("plugin %q is nil", name))
+		default:
+			if v := reflect.ValueOf(p); v.Kind() == reflect.Pointer && v.IsNil() {
+				errs = append(errs, fmt.Errorf("plugin %q is a nil %T", name, p))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateVersionedPlugins validates each PluginSet of versionedPlugins.
+func validateVersionedPlugins(versionedPlugins map[int]PluginSet) error {
+	versions := make([]int, 0, len(versionedPlugins))
+	for v := range versionedPlugins {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+
+	for _, v := range versions {
+		if err := versionedPlugins[v].Validate(); err != nil {
+			return fmt.Errorf("invalid plugin set for protocol version %d: %w", v, err)
+		}
+	}
+	return nil
+}
+
 // ServeConfig configures what sorts of plugins are served.
 type ServeConfig struct {
 	// HandshakeConfig is the configuration that must match clients.
@@ -55,21 +102,80 @@ type ServeConfig struct {
 	// TLSProvider is a function that returns a configured tls.Config.
 	TLSProvider func() (*tls.Config, error)
 
+	// BrokerTLSProvider is a function that returns the tls.Config used for
+	// connections brokered through GRPCBroker. If nil, brokered connections
+	// use the same configuration as the main connection, which is the
+	// configuration from TLSProvider or the one negotiated by AutoMTLS.
+	//
+	// The host must be configured with a matching ClientConfig.BrokerTLSConfig,
+	// because AutoMTLS only negotiates certificates for the main connection.
+	BrokerTLSProvider func() (*tls.Config, error)
+
+	// MessageMAC enables integrity checking of unary RPCs with a MAC keyed
+	// from the magic cookie. It must match ClientConfig.MessageMAC.
+	MessageMAC bool
+
+	// DebugDumper, if set, reports the internal state of the plugin when
+	// the host calls Client.DebugDump. Otherwise the host gets
+	// ErrDebugDumpUnsupported.
+	DebugDumper DebugDumper
+
+	// HealthReporter, if set, reports the detailed health of the plugin
+	// when the host calls Client.HealthDetails. Otherwise the host gets
+	// ErrHealthDetailsUnsupported.
+	HealthReporter HealthReporter
+
 	// VersionedPlugins is a map of PluginSets for specific protocol versions.
 	// These can be used to negotiate a compatible version between client and
 	// server. If this is set, Handshake.ProtocolVersion is not required.
 	VersionedPlugins map[int]PluginSet
 
-	// GRPCServer should be non-nil to enable serving the plugins over
-	// gRPC. This is a function to create the server when needed with the
-	// given server options. The server options populated by go-plugin will
-	// be for TLS if set. You may modify the input slice.
+	// GRPCServer is a function to create the server when needed with the
+	// given server options. If nil, DefaultGRPCServer is used.
+	//
+	// The options hold everything the plugin needs to work: the transport
+	// credentials if TLS is used and the interceptors that implement
+	// cancellation reasons and MessageMAC. The function must pass all of
+	// them to grpc.NewServer, and may append its own, such as
+	// grpc.StatsHandler or grpc.ChainUnaryInterceptor options, which are
+	// composed with the built-in ones. Interceptors must be added with the
+	// Chain options, since grpc.UnaryInterceptor and grpc.StreamInterceptor
+	// can only be set once.
 	//
 	// Note that the grpc.Server will automatically be registered with
 	// the gRPC health checking service. This is not optional since go-plugin
 	// relies on this to implement Ping().
 	GRPCServer func([]grpc.ServerOption) *grpc.Server
 
+	// HealthServer, if set, is the health service through which the plugin
+	// reports whether it is serving, see HealthServer. If nil, the plugin
+	// always reports SERVING.
+	HealthServer *HealthServer
+
+	// UnaryInterceptors and StreamInterceptors are chained, in order, on
+	// the gRPC server, e.g. for panic recovery or request logging, without
+	// having to set GRPCServer. See GRPCServer.UnaryInterceptors.
+	UnaryInterceptors  []grpc.UnaryServerInterceptor
+	StreamInterceptors []grpc.StreamServerInterceptor
+
+	// KeepaliveParams and KeepaliveEnforcementPolicy configure the keepalive
+	// of the gRPC server, see GRPCServer.KeepaliveParams.
+	KeepaliveParams            *keepalive.ServerParameters
+	KeepaliveEnforcementPolicy *keepalive.EnforcementPolicy
+
+	// MaxRecvMsgSize and MaxSendMsgSize are the largest messages in bytes
+	// the gRPC server receives and sends, see GRPCServer.MaxRecvMsgSize.
+	MaxRecvMsgSize int
+	MaxSendMsgSize int
+
+	// ListenerFunc, if set, creates the listener the plugin serves on
+	// instead of the TCP port or Unix socket chosen from the environment
+	// the client set, e.g. to set custom socket options. Its address is
+	// advertised in the handshake, so it must be a "tcp" or "unix" address
+	// the host can dial. Connections brokered through GRPCBroker still use
+	// listeners chosen from the environment.
+	ListenerFunc func() (net.Listener, error)
+
 	// Logger is used to pass a logger into the server. If none is provided the
 	// server will create a default logger.
 	Logger *slog.Logger
@@ -82,6 +188,9 @@ type ServeConfig struct {
 // exit with a status code of 1. Serve will panic for unexpected
 // conditions where a user's fix is unknown.
 //
+// If the gRPC server stops with an error, see GRPCServer.Err, Serve exits
+// the process with a status code of 1 too.
+//
 // This is the method that plugins should call in their main() functions.
 func Serve(opts *ServeConfig) {
 	exitCode := -1
@@ -116,6 +225,11 @@ func Serve(opts *ServeConfig) {
 		// internal logger to os.Stderr
 		l = log.NewLogger(&log.HandlerOptions{Name: "plugin", AddSource: false})
 	}
+	if err := validateVersionedPlugins(opts.VersionedPlugins); err != nil {
+		l.Error("cannot initialize plugin", "error", err)
+		return
+	}
+
 	// negotiate the version and plugins
 	// start with default version in the handshake config
 	protoVersion, pluginSet, err := protocolVersion(opts)
@@ -124,10 +238,38 @@ func Serve(opts *ServeConfig) {
 		return
 	}
 
-	// Register a listener so we can accept a connection
-	listener, err := serverListener(unixSocketConfigFromEnv())
+	compressThreshold, err := compressThresholdFromEnv()
+	if err != nil {
+		l.Error("cannot initialize plugin", "error", err)
+		writeDiagnostic(os.Stderr, &StartupDiagnostic{
+			Stage:   DiagnosticStageConfig,
+			Message: err.Error(),
+		})
+		exitCode = 1
+		return
+	}
+
+	// Register a listener so we can accept a connection. Only this one
+	// listens on the fixed path, if the client asked for one.
+	var listener net.Listener
+	network := serverListenerNetwork()
+	if opts.ListenerFunc != nil {
+		network = ""
+		listener, err = opts.ListenerFunc()
+	} else {
+		socketCfg := unixSocketConfigFromEnv()
+		socketCfg.socketPath = os.Getenv(EnvUnixSocketPath)
+		listener, err = serverListener(socketCfg)
+	}
 	if err != nil {
 		l.Error("cannot initialize plugin", "error", err)
+		// Let the client know why we never got to the handshake.
+		writeDiagnostic(os.Stderr, &StartupDiagnostic{
+			Stage:   DiagnosticStageListen,
+			Network: network,
+			Code:    diagnosticCode(err),
+			Message: err.Error(),
+		})
 		return
 	}
 
@@ -157,7 +299,13 @@ func Serve(opts *ServeConfig) {
 			l.Error("client cert provided but failed to parse", "cert", clientCert)
 		}
 
-		certPEM, keyPEM, err := generateCert()
+		certConfig, err := autoMTLSCertConfigFromEnv()
+		if err != nil {
+			l.Error("invalid automatic mTLS certificate config", "error", err)
+			panic(err)
+		}
+
+		certPEM, keyPEM, err := generateCert(certConfig)
 		if err != nil {
 			l.Error("failed to generate server certificate", "error", err)
 			panic(err)
@@ -183,6 +331,18 @@ func Serve(opts *ServeConfig) {
 		serverCert = base64.RawStdEncoding.EncodeToString(cert.Certificate[0])
 	}
 
+	var brokerTLSConfig *tls.Config
+	if opts.BrokerTLSProvider != nil {
+		brokerTLSConfig, err = opts.BrokerTLSProvider()
+		if err == nil {
+			err = validateBrokerTLS(brokerTLSConfig)
+		}
+		if err != nil {
+			l.Error("cannot initialize plugin broker tls", "error", err)
+			return
+		}
+	}
+
 	// Create the channel to tell us when we're done
 	doneCh := make(chan struct{})
 
@@ -200,14 +360,36 @@ func Serve(opts *ServeConfig) {
 		os.Exit(1)
 	}
 
+	grpcServer := opts.GRPCServer
+	if grpcServer == nil {
+		grpcServer = DefaultGRPCServer
+	}
+
+	var macKey []byte
+	if opts.MessageMAC {
+		macKey = deriveMACKey(opts.HandshakeConfig)
+	}
+
 	server := &GRPCServer{
-		Plugins: pluginSet,
-		Server:  opts.GRPCServer,
-		TLS:     tlsConfig,
-		Stdout:  stdout_r,
-		Stderr:  stderr_r,
-		DoneCh:  doneCh,
-		logger:  l,
+		Plugins:                    pluginSet,
+		Server:                     grpcServer,
+		TLS:                        tlsConfig,
+		BrokerTLS:                  brokerTLSConfig,
+		MACKey:                     macKey,
+		DebugDumper:                opts.DebugDumper,
+		HealthReporter:             opts.HealthReporter,
+		HealthServer:               opts.HealthServer,
+		UnaryInterceptors:          opts.UnaryInterceptors,
+		StreamInterceptors:         opts.StreamInterceptors,
+		CompressThreshold:          compressThreshold,
+		KeepaliveParams:            opts.KeepaliveParams,
+		KeepaliveEnforcementPolicy: opts.KeepaliveEnforcementPolicy,
+		MaxRecvMsgSize:             opts.MaxRecvMsgSize,
+		MaxSendMsgSize:             opts.MaxSendMsgSize,
+		Stdout:                     stdout_r,
+		Stderr:                     stderr_r,
+		DoneCh:                     doneCh,
+		logger:                     l,
 	}
 
 	// Initialize the servers
@@ -216,17 +398,41 @@ func Serve(opts *ServeConfig) {
 		return
 	}
 
+	// The seccomp profile is installed once the plugin is set up, so it
+	// only has to allow what serving needs.
+	if os.Getenv(EnvSandboxSeccomp) != "" {
+		if err := installSeccomp(); err != nil {
+			l.Error("cannot initialize plugin sandbox", "error", err)
+			writeDiagnostic(os.Stderr, &StartupDiagnostic{
+				Stage:   DiagnosticStageSandbox,
+				Message: err.Error(),
+			})
+			exitCode = 1
+			return
+		}
+	}
+
 	l.Debug("plugin address",
 		"network", listener.Addr().Network(),
 		"address", listener.Addr().String(),
 	)
 
-	fmt.Printf("%d|%d|%s|%s|%s\n",
+	// Output the address and service name to stdout so that the client can
+	// bring it up. The fields are the core protocol version, the negotiated
+	// app protocol version, the network and address to connect to, the
+	// protocol, the AutoMTLS server certificate and the library version,
+	// after the prefix the client asked for, if any. Earlier versions of
+	// this library printed the certificate in place of the protocol and
+	// no library version; the client accepts both layouts.
+	fmt.Printf("%s%d|%d|%s|%s|%s|%s|%s\n",
+		os.Getenv(EnvHandshakePrefix),
 		CoreProtocolVersion,
 		protoVersion,
 		listener.Addr().Network(),
 		listener.Addr().String(),
-		serverCert)
+		"grpc",
+		serverCert,
+		LibraryVersion)
 	os.Stdout.Sync()
 
 	ch := make(chan os.Signal, 1)
@@ -251,7 +457,12 @@ func Serve(opts *ServeConfig) {
 		// Note that given the documentation of Serve we should probably be
 		// setting exitCode = 0 and using os.Exit here. That's how it used to
 		// work before extracting this library. However, for years we've done
-		// this so we'll keep this functionality.
+		// this so we'll keep this functionality. A server that failed makes
+		// the plugin exit with an error though, so the host sees it crashed.
+		// The error was logged by the server.
+		if server.Err() != nil {
+			exitCode = 1
+		}
 	}
 }
 
@@ -300,14 +511,39 @@ func protocolVersion(opts *ServeConfig) (int, PluginSet, error) {
 }
 
 func serverListener(unixSocketCfg UnixSocketConfig) (net.Listener, error) {
-	if runtime.GOOS == "windows" {
+	if serverListenerNetwork() == "tcp" {
 		return serverListener_tcp()
 	}
 
 	return serverListener_unix(unixSocketCfg)
 }
 
+// serverListenerNetwork returns the network serverListener listens on.
+func serverListenerNetwork() string {
+	if runtime.GOOS == "windows" || os.Getenv(EnvFixedPort) != "" || os.Getenv(EnvForceTCP) != "" {
+		return "tcp"
+	}
+	return "unix"
+}
+
 func serverListener_tcp() (net.Listener, error) {
+	host := os.Getenv(EnvBindAddress)
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	if envFixedPort := os.Getenv(EnvFixedPort); envFixedPort != "" {
+		port, err := strconv.ParseUint(envFixedPort, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get value from %s: %v", EnvFixedPort, err)
+		}
+		listener, err := net.Listen("tcp", net.JoinHostPort(host, strconv.FormatUint(port, 10)))
+		if err != nil {
+			return nil, fmt.Errorf("cannot bind plugin TCP listener on fixed port %d: %w", port, err)
+		}
+		return listener, nil
+	}
+
 	envMinPort := os.Getenv("PLUGIN_MIN_PORT")
 	envMaxPort := os.Getenv("PLUGIN_MAX_PORT")
 
@@ -339,42 +575,62 @@ func serverListener_tcp() (net.Listener, error) {
 	}
 
 	for port := minPort; port <= maxPort; port++ {
-		address := fmt.Sprintf("127.0.0.1:%d", port)
+		address := net.JoinHostPort(host, strconv.FormatInt(port, 10))
 		listener, err := net.Listen("tcp", address)
 		if err == nil {
 			return listener, nil
 		}
 	}
 
-	return nil, errors.New("cannot bind plugin TCP listener")
+	return nil, fmt.Errorf("cannot bind plugin TCP listener on %s: %w (%d-%d)", host, ErrNoAvailablePort, minPort, maxPort)
 }
 
+// socketNameAttempts is how many unique names are tried when creating a
+// socket that collides with one created concurrently.
+const socketNameAttempts = 10
+
 func serverListener_unix(unixSocketCfg UnixSocketConfig) (net.Listener, error) {
-	tf, err := os.CreateTemp(unixSocketCfg.socketDir, "plugin")
-	if err != nil {
-		return nil, err
+	path := unixSocketCfg.socketPath
+	var l net.Listener
+	if path != "" {
+		var err error
+		if l, err = net.Listen("unix", path); err != nil {
+			return nil, err
+		}
 	}
-	path := tf.Name()
+	for attempt := 0; l == nil; attempt++ {
+		if attempt == socketNameAttempts {
+			return nil, fmt.Errorf("cannot create a unique unix socket in %q after %d attempts", unixSocketCfg.socketDir, attempt)
+		}
 
-	// Close the file and remove it because it has to not exist for
-	// the domain socket.
-	if err := tf.Close(); err != nil {
-		return nil, err
-	}
-	if err := os.Remove(path); err != nil {
-		return nil, err
-	}
+		tf, err := os.CreateTemp(unixSocketCfg.socketDir, "plugin")
+		if err != nil {
+			return nil, err
+		}
+		path = tf.Name()
 
-	l, err := net.Listen("unix", path)
-	if err != nil {
-		return nil, err
+		// Close the file and remove it because it has to not exist for
+		// the domain socket.
+		if err := tf.Close(); err != nil {
+			return nil, err
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+
+		// Another plugin sharing the directory may have taken the name
+		// between the removal and the listen.
+		l, err = net.Listen("unix", path)
+		if err != nil && !errors.Is(err, syscall.EADDRINUSE) {
+			return nil, err
+		}
 	}
 
 	// By default, unix sockets are only writable by the owner. Set up a custom
 	// group owner and group write permissions if configured.
 	if unixSocketCfg.Group != "" {
-		err = setGroupWritable(path, unixSocketCfg.Group, 0o660)
-		if err != nil {
+		if err := setGroupWritable(path, unixSocketCfg.Group, 0o660); err != nil {
+			l.Close()
 			return nil, err
 		}
 	}
@@ -387,6 +643,13 @@ func serverListener_unix(unixSocketCfg UnixSocketConfig) (net.Listener, error) {
 	}, nil
 }
 
+// createSocketDir creates a uniquely named directory in tempDir for the unix
+// sockets of a plugin. os.MkdirTemp retries by itself if the name collides
+// with a directory created concurrently by another client.
+func createSocketDir(tempDir string) (string, error) {
+	return os.MkdirTemp(tempDir, fmt.Sprintf("plugin-dir-%d-", os.Getpid()))
+}
+
 func setGroupWritable(path, groupString string, mode os.FileMode) error {
 	groupID, err := strconv.Atoi(groupString)
 	if err != nil {
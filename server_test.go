@@ -0,0 +1,39 @@
+package plugin
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestServe_serverError(t *testing.T) {
+	exited := make(chan error, 1)
+	config := testClientConfig("serve-error")
+	config.OnExit = func(err error) { exited <- err }
+	c := NewClient(config)
+	defer c.Kill()
+	// The plugin may exit before or after the handshake is read.
+	c.Start()
+
+	select {
+	case err := <-exited:
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+			t.Fatalf("plugin exited with %v, want exit status 1", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("plugin did not exit")
+	}
+}
+
+func TestServe_invalidCompressThreshold(t *testing.T) {
+	c := NewClient(testClientConfig("test-grpc", EnvCompressThreshold+"=abc"))
+	defer c.Kill()
+
+	_, err := c.Start()
+	var diag *StartupDiagnostic
+	if !errors.As(err, &diag) || diag.Stage != DiagnosticStageConfig {
+		t.Fatalf("expected a %s diagnostic, got %v", DiagnosticStageConfig, err)
+	}
+}
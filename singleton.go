@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrPluginAlreadyRunning is returned by Start when ClientConfig.Singleton
+// is set and another instance of the plugin is running. The error returned
+// by Start wraps it and names the pid of the running instance, if known.
+var ErrPluginAlreadyRunning = errors.New("plugin is already running")
+
+// singletonLock is the lock held while a ClientConfig.Singleton plugin runs.
+type singletonLock struct {
+	f    *os.File
+	once sync.Once
+}
+
+// singletonLockPath returns the path of the lock file of the plugin binary
+// at path. Every host locks the same file for the same binary.
+func singletonLockPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(os.TempDir(), "plugin-"+hex.EncodeToString(sum[:8])+".lock"), nil
+}
+
+// setPid records the pid of the running instance in the lock file, for the
+// error returned to other hosts.
+func (l *singletonLock) setPid(pid string) {
+	if err := l.f.Truncate(0); err != nil {
+		return
+	}
+	l.f.WriteAt([]byte(pid+"\n"), 0)
+}
+
+// release unlocks the lock. It can safely be called multiple times.
+func (l *singletonLock) release() {
+	if l == nil {
+		return
+	}
+	l.once.Do(func() {
+		l.f.Truncate(0)
+		unlockFile(l.f)
+		l.f.Close()
+	})
+}
+
+// runningPid returns the pid recorded in the lock file f, or 0.
+func runningPid(f *os.File) int {
+	b := make([]byte, 32)
+	n, _ := f.ReadAt(b, 0)
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(b[:n])))
+	return pid
+}
@@ -0,0 +1,40 @@
+//go:build !windows
+// +build !windows
+
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// acquireSingleton locks the lock file of the plugin binary at path, and
+// returns an error wrapping ErrPluginAlreadyRunning if it is already locked.
+func acquireSingleton(path string) (*singletonLock, error) {
+	lockPath, err := singletonLockPath(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening singleton lock: %w", err)
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			if pid := runningPid(f); pid != 0 {
+				return nil, fmt.Errorf("%w (pid %d)", ErrPluginAlreadyRunning, pid)
+			}
+			return nil, ErrPluginAlreadyRunning
+		}
+		return nil, fmt.Errorf("error locking singleton lock: %w", err)
+	}
+	return &singletonLock{f: f}, nil
+}
+
+func unlockFile(f *os.File) {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package plugin
+
+import (
+	"errors"
+	"os"
+)
+
+func acquireSingleton(string) (*singletonLock, error) {
+	return nil, errors.New("Singleton is not supported on windows")
+}
+
+func unlockFile(*os.File) {}
@@ -0,0 +1,60 @@
+package plugin
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// parseSPIFFEID parses a SPIFFE ID, or a trust domain given as an ID without
+// a path, e.g. "spiffe://example.org".
+func parseSPIFFEID(id string) (*url.URL, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SPIFFE ID %q: %w", id, err)
+	}
+	if u.Scheme != "spiffe" || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid SPIFFE ID %q", id)
+	}
+	return u, nil
+}
+
+// matchSPIFFEID reports whether id matches expected. An expected ID without
+// a path matches every ID of its trust domain.
+func matchSPIFFEID(expected, id *url.URL) bool {
+	if id.Scheme != "spiffe" || id.Host != expected.Host {
+		return false
+	}
+	return expected.Path == "" || expected.Path == "/" || expected.Path == id.Path
+}
+
+// withSPIFFEVerification returns a copy of cfg that also requires the leaf
+// certificate of the plugin to carry a SPIFFE ID matching expected.
+func withSPIFFEVerification(cfg *tls.Config, expected string) (*tls.Config, error) {
+	want, err := parseSPIFFEID(expected)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg = cfg.Clone()
+	next := cfg.VerifyConnection
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if next != nil {
+			if err := next(cs); err != nil {
+				return err
+			}
+		}
+
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("plugin presented no certificate")
+		}
+		for _, uri := range cs.PeerCertificates[0].URIs {
+			if matchSPIFFEID(want, uri) {
+				return nil
+			}
+		}
+		return fmt.Errorf("plugin certificate does not carry a SPIFFE ID matching %s", expected)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,40 @@
+package plugin
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+// doneStream calls done once the stream it wraps has finished: when RecvMsg
+// returns an error, after the single response of a stream the server
+// doesn't stream, such as a client-streaming call ended by CloseAndRecv, or
+// when ctx, the context the stream was created with, is done, e.g. because
+// it was cancelled without another RecvMsg.
+type doneStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	done          func()
+	stop          func() bool
+	once          sync.Once
+}
+
+func newDoneStream(ctx context.Context, stream grpc.ClientStream, desc *grpc.StreamDesc, done func()) *doneStream {
+	s := &doneStream{ClientStream: stream, serverStreams: desc.ServerStreams, done: done}
+	s.stop = context.AfterFunc(ctx, s.finish)
+	return s
+}
+
+func (s *doneStream) finish() {
+	s.once.Do(s.done)
+}
+
+func (s *doneStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.serverStreams {
+		s.stop()
+		s.finish()
+	}
+	return err
+}
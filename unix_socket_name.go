@@ -0,0 +1,57 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// maxUnixSocketPath is the longest path a unix socket can be bound to on
+// all supported platforms, which is the 104 bytes of sun_path on macOS and
+// the BSDs, minus the terminating NUL.
+const maxUnixSocketPath = 103
+
+// fixedSocketPath returns the path of the socket the plugin listens on when
+// UnixSocketConfig.FixedName is set. It fails if the path is too long or if
+// another plugin is listening on it, and removes a stale socket left behind
+// by a plugin that did not clean up.
+func fixedSocketPath(cfg UnixSocketConfig) (string, error) {
+	name := cfg.FixedName
+	if name == "." || name == ".." || filepath.Base(name) != name {
+		return "", fmt.Errorf("invalid UnixSocketConfig.FixedName %q: must be a file name", name)
+	}
+
+	dir := cfg.TempDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	path := filepath.Join(dir, name)
+	if len(path) > maxUnixSocketPath {
+		return "", fmt.Errorf("unix socket path %q is longer than %d bytes", path, maxUnixSocketPath)
+	}
+
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return path, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return "", fmt.Errorf("cannot create unix socket %q: a file exists at the path", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return "", fmt.Errorf("unix socket %q is in use by another plugin", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return "", fmt.Errorf("cannot remove stale unix socket %q: %w", path, err)
+	}
+	return path, nil
+}
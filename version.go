@@ -0,0 +1,30 @@
+package plugin
+
+import (
+	"strings"
+)
+
+// LibraryVersion is the semantic version of this package. Plugin servers
+// advertise it in the handshake so that hosts can detect plugins built
+// against a different release of the library.
+const LibraryVersion = "v0.1.0"
+
+// libraryVersionsCompatible reports whether two library versions are expected
+// to behave the same way. Versions are compatible when their major versions
+// match, or for v0 releases, when their minor versions match as well.
+func libraryVersionsCompatible(a, b string) bool {
+	aMajor, aMinor := majorMinor(a)
+	bMajor, bMinor := majorMinor(b)
+	if aMajor != bMajor {
+		return false
+	}
+	return aMajor != "0" || aMinor == bMinor
+}
+
+func majorMinor(v string) (string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(v, "v"), ".", 3)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}